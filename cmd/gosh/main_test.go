@@ -162,7 +162,7 @@ var interactiveTests = []struct {
 			"(\n",
 			"> ",
 		},
-		wantErr: "1:1: reached EOF without matching ( with )",
+		wantErr: "1:1: reached EOF at 2:1 without matching ( with )",
 	},
 }
 
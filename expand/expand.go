@@ -190,6 +190,30 @@ func Pattern(cfg *Config, word *syntax.Word) (string, error) {
 	return buf.String(), nil
 }
 
+// Regexp expands a single shell word as an extended regular expression, like
+// the right side of the =~ operator in Bash's [[ ]] tests. Any quoted parts of
+// the input word match literally, using regexp.QuoteMeta, while the rest is
+// kept as regular expression syntax. No globbing or field splitting is done.
+//
+// The config specifies shell expansion options; nil behaves the same as an
+// empty config.
+func Regexp(cfg *Config, word *syntax.Word) (string, error) {
+	cfg = prepareConfig(cfg)
+	field, err := cfg.wordField(word.Parts, quoteNone)
+	if err != nil {
+		return "", err
+	}
+	buf := cfg.strBuilder()
+	for _, part := range field {
+		if part.quote > quoteNone {
+			buf.WriteString(regexp.QuoteMeta(part.val))
+		} else {
+			buf.WriteString(part.val)
+		}
+	}
+	return buf.String(), nil
+}
+
 // Format expands a format string with a number of arguments, following the
 // shell's format specifications. These include printf(1), among others.
 //
@@ -216,6 +216,11 @@ var runTests = []runTest{
 	{"true foo", ""},
 	{": foo", ""},
 	{"! true", "exit status 1"},
+	{"! ! true", ""},
+	{"! ! false", "exit status 1"},
+	{"! ! (exit 3)", "exit status 1"},
+	{"! ! ! (exit 3)", ""},
+	{"set -e; ! ! false; echo foo", "foo\n"},
 	{"false; true", ""},
 	{"false; exit", "exit status 1"},
 	{"exit; echo foo", ""},
@@ -291,6 +296,10 @@ var runTests = []runTest{
 	{`a=" b c "; echo $a`, "b c\n"},
 	{`a=" b c "; echo "$a"`, " b c \n"},
 	{`echo "$(echo ' b c ')"`, " b c \n"},
+	{"echo `echo \\`echo \\\\\\`echo deep\\\\\\`\\``", "deep\n"},
+	{"x=a; echo `echo \\$x \\`echo \\\\$x\\``", "a a\n"},
+	{"x=`echo a\\\\`; echo \"$x\"", "a\\\n"},
+	{"x=`echo \\`echo a\\\\\\\\\\``; echo \"$x\"", "a\\\n"},
 	{"echo ''", "\n"},
 	{`$(echo)`, ""},
 	{`echo -n '\\'`, `\\`},
@@ -1012,6 +1021,10 @@ var runTests = []runTest{
 		"a=sub true & { a=main $ENV_PROG | grep '^a='; }",
 		"a=main\n",
 	},
+	{
+		"echo a$()b \"$( )\" ``; echo c``d",
+		"ab \ncd\n",
+	},
 	{
 		"echo foo >f; echo $(cat f); echo $(<f)",
 		"foo\nfoo\n",
@@ -1020,6 +1033,10 @@ var runTests = []runTest{
 		"echo foo >f; echo $(<f; echo bar)",
 		"bar\n",
 	},
+	{
+		"printf 'foo\\nbar\\n\\n' >f; x=\"$(< f)\"; echo \"$x\"",
+		"foo\nbar\n",
+	},
 
 	// pipes
 	{
@@ -1069,6 +1086,22 @@ var runTests = []runTest{
 		"echo foo >>a; echo bar &>>a; wc -c <a",
 		"8\n",
 	},
+	{
+		"echo foo >a; echo bar >|a; cat a",
+		"bar\n",
+	},
+	{
+		"echo foo >a; cat <>a; cat 0<>a",
+		"foo\nfoo\n",
+	},
+	{
+		"echo foo >a; echo x 1<>a; cat a; echo y 1<>b; cat b",
+		"x\no\ny\n",
+	},
+	{
+		"exec 3<>a; echo visible",
+		"unsupported file descriptor: 3\nvisible\n #IGNORE",
+	},
 	{
 		"{ echo a; echo b >&2; } &>/dev/null",
 		"",
@@ -1105,6 +1138,14 @@ var runTests = []runTest{
 		"cat <<-EOF\n\tfoo\n\nEOF",
 		"foo\n\n",
 	},
+	{
+		"cat <<-EOF\n\tfoo\n\t  bar\n  \tbaz\n\t\tEOF",
+		"foo\n  bar\n  \tbaz\n",
+	},
+	{
+		"cat <<-EOF\nfoo\n  EOF\nEOF",
+		"foo\n  EOF\n",
+	},
 	{
 		"cat <<EOF\nfoo\\\nbar\nEOF",
 		"foobar\n",
@@ -1181,6 +1222,22 @@ var runTests = []runTest{
 		"[[ a || '' ]]",
 		"",
 	},
+	{
+		"[[ '' && a || b ]]",
+		"",
+	},
+	{
+		"[[ '' && (a || b) ]]",
+		"exit status 1",
+	},
+	{
+		"[[ ! '' && '' ]]",
+		"exit status 1",
+	},
+	{
+		"[[ ! ('' && a) ]]",
+		"",
+	},
 	{
 		"[[ a > 3 ]]",
 		"",
@@ -1325,6 +1382,26 @@ var runTests = []runTest{
 		"[[ a =~ [ ]]",
 		"exit status 2",
 	},
+	{
+		"x=123; [[ $x =~ ^[0-9]+$ ]] && echo num; [[ $x =~ ^[a-z]+$ ]] || echo notword",
+		"num\nnotword\n",
+	},
+	{
+		`[[ literal. =~ "literal." ]] && echo a; [[ literalx =~ "literal." ]] || echo b`,
+		"a\nb\n",
+	},
+	{
+		`[[ literalx =~ literal. ]] && echo a; [[ a.b =~ ^a'.'b$ ]] && echo b; [[ axb =~ ^a'.'b$ ]] || echo c`,
+		"a\nb\nc\n",
+	},
+	{
+		`re='^a.c$'; [[ abc =~ $re ]] && echo a; [[ abc =~ "$re" ]] || echo b`,
+		"a\nb\n",
+	},
+	{
+		"[[ a.go =~ *.go ]]",
+		"exit status 2",
+	},
 	{
 		"[[ -e a ]] && echo x; >a; [[ -e a ]] && echo y",
 		"y\n",
@@ -1586,6 +1663,26 @@ var runTests = []runTest{
 		"a=1; let a++; echo $a",
 		"2\n",
 	},
+	{
+		`let "a = 1 + 2" 'b = a < 4' c=5; echo $a $b $c`,
+		"3 1 5\n",
+	},
+	{
+		`let "0 * 1"`,
+		"exit status 1",
+	},
+	{
+		`x='1 + 2'; let "a = $x * 2" "b = a"; echo $a $b`,
+		"5 5\n",
+	},
+	{
+		`let "a = (1"; echo $?`,
+		"let: 1:5: reached EOF at 1:7 without matching ( with )\n1\n #IGNORE",
+	},
+	{
+		`let ""; echo $?`,
+		"let: \"\": expression expected\n1\n #IGNORE",
+	},
 	{
 		"a=$((1 + 2)); echo $a",
 		"3\n",
@@ -1824,7 +1921,7 @@ set +o pipefail
 	{"eval echo foo", "foo\n"},
 	{"eval 'echo foo'", "foo\n"},
 	{"eval 'exit 1'", "exit status 1"},
-	{"eval '('", "eval: 1:1: reached EOF without matching ( with )\nexit status 1 #JUSTERR"},
+	{"eval '('", "eval: 1:1: reached EOF at 1:2 without matching ( with )\nexit status 1 #JUSTERR"},
 	{"set a b; eval 'echo $@'", "a b\n"},
 	{"eval 'a=foo'; echo $a", "foo\n"},
 	{`a=b eval "echo $a"`, "\n"},
@@ -1939,6 +2036,18 @@ set +o pipefail
 		"a=bbb; a+=(c d); echo ${a[@]}",
 		"bbb c d\n",
 	},
+	{
+		"s=foo; s+=bar; a=(x y); a[0]+=1 a[1]+=2; echo $s ${a[@]}",
+		"foobar x1 y2\n",
+	},
+	{
+		"a=(x); a+=(y) a[0]+=1; a+=(z); echo ${a[@]}",
+		"x1 y z\n",
+	},
+	{
+		"declare -A m=([k]=x); m[k]+=y m[j]+=z; echo ${m[k]} ${m[j]}",
+		"xy z\n",
+	},
 	{
 		`a=('a  1' 'b  2'); for e in ${a[@]}; do echo "$e"; done`,
 		"a\n1\nb\n2\n",
@@ -2048,6 +2157,12 @@ set +o pipefail
 	{"export foo=(1 2); $ENV_PROG | grep '^foo='", "exit status 1"},
 	{"declare -A foo=([a]=b); export foo; $ENV_PROG | grep '^foo='", "exit status 1"},
 	{"export foo=(b c); foo=x; $ENV_PROG | grep '^foo='", "exit status 1"},
+	{"export foo=bar; export -n foo; $ENV_PROG | grep '^foo='; echo $foo", "bar\n"},
+	{"foo() { echo foo; }; export -f foo; foo", "foo\n"},
+	{
+		"export -f foo",
+		"export: foo: not a function\nexit status 1 #JUSTERR",
+	},
 
 	// local
 	{
@@ -3114,6 +3229,37 @@ func TestRunnerAltNodes(t *testing.T) {
 	}
 }
 
+func TestRunnerTestCmds(t *testing.T) {
+	t.Parallel()
+	in := "[ -n foo ] && echo yes; test a = b || echo no\nset -e; [ x = y ]; echo unreachable"
+	file := parse(t, syntax.NewParser(syntax.TestCmds(true)), in)
+	var cb concBuffer
+	r, _ := New(StdIO(nil, &cb, &cb))
+	if err := r.Run(context.Background(), file); err != nil {
+		cb.WriteString(err.Error())
+	}
+	want := "yes\nno\nexit status 1"
+	if got := cb.String(); got != want {
+		t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", in, want, got)
+	}
+}
+
+func TestRunnerAliasClauses(t *testing.T) {
+	t.Parallel()
+	in := "alias foo='echo foo' bar\nshopt -s expand_aliases\nalias foo; foo x\n" +
+		"v=x; alias b=\"echo $v\" c=; alias b c"
+	file := parse(t, syntax.NewParser(syntax.AliasClauses(true)), in)
+	var cb concBuffer
+	r, _ := New(StdIO(nil, &cb, &cb))
+	if err := r.Run(context.Background(), file); err != nil {
+		cb.WriteString(err.Error())
+	}
+	want := "alias: \"bar\" not found\nalias foo='echo foo'\nfoo x\nalias b='echo x'\nalias c=''\n"
+	if got := cb.String(); got != want {
+		t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", in, want, got)
+	}
+}
+
 func TestElapsedString(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
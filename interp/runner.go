@@ -99,7 +99,8 @@ func (r *Runner) fillExpandConfig(ctx context.Context) {
 // catShortcutArg checks if a statement is of the form "$(<file)". The redirect
 // word is returned if there's a match, and nil otherwise.
 func catShortcutArg(stmt *syntax.Stmt) *syntax.Word {
-	if stmt.Cmd != nil || stmt.Negated || stmt.Background || stmt.Coprocess {
+	if stmt.Cmd != nil || stmt.Negated || stmt.Negations > 0 ||
+		stmt.Background || stmt.Coprocess {
 		return nil
 	}
 	if len(stmt.Redirs) != 1 {
@@ -135,6 +136,37 @@ func (r *Runner) arithm(expr syntax.ArithmExpr) int {
 	return n
 }
 
+// letExpr returns the expression to evaluate for an operand of let. Like in
+// Bash, a quoted word such as "x = 1 + 2" holds an expression of its own, which
+// is parsed once the word is expanded. If it is invalid, nil is returned.
+func (r *Runner) letExpr(expr syntax.ArithmExpr) syntax.ArithmExpr {
+	w, ok := expr.(*syntax.Word)
+	if !ok || !quotedWord(w) {
+		return expr
+	}
+	str := r.literal(w)
+	expr, err := syntax.NewParser().Arithmetic(strings.NewReader(str))
+	if err == nil && expr == nil {
+		err = fmt.Errorf("%q: expression expected", str)
+	}
+	if err != nil {
+		r.errf("let: %v\n", err)
+		r.exit = 1
+		return nil
+	}
+	return expr
+}
+
+func quotedWord(w *syntax.Word) bool {
+	for _, wp := range w.Parts {
+		switch wp.(type) {
+		case *syntax.SglQuoted, *syntax.DblQuoted:
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Runner) fields(words ...*syntax.Word) []string {
 	strs, err := expand.Fields(r.ecfg, words...)
 	r.expandErr(err)
@@ -159,6 +191,12 @@ func (r *Runner) pattern(word *syntax.Word) string {
 	return str
 }
 
+func (r *Runner) regexp(word *syntax.Word) string {
+	str, err := expand.Regexp(r.ecfg, word)
+	r.expandErr(err)
+	return str
+}
+
 // expandEnv exposes Runner's variables to the expand package.
 type expandEnv struct {
 	r *Runner
@@ -276,7 +314,10 @@ func (r *Runner) stmtSync(ctx context.Context, st *syntax.Stmt) {
 	}
 	if st.Negated {
 		r.exit = oneIf(r.exit == 0)
-	} else if _, ok := st.Cmd.(*syntax.CallExpr); !ok {
+	} else if st.Negations > 0 {
+		// "! ! stmt" only keeps whether it failed
+		r.exit = oneIf(r.exit != 0)
+	} else if !isSimpleCmd(st.Cmd) {
 	} else if r.exit != 0 && !r.noErrExit && r.opts[optErrExit] {
 		// If the "errexit" option is set and a simple command failed,
 		// exit the shell. Exceptions:
@@ -291,6 +332,32 @@ func (r *Runner) stmtSync(ctx context.Context, st *syntax.Stmt) {
 	}
 }
 
+// isSimpleCmd reports whether cmd is a simple command, which is what the
+// "errexit" option applies to.
+func isSimpleCmd(cmd syntax.Command) bool {
+	switch cmd.(type) {
+	case *syntax.CallExpr, *syntax.TestCmd, *syntax.AliasClause:
+		return true
+	}
+	return false
+}
+
+// aliasDefWord joins an alias definition back into the single argument which
+// the alias builtin expects, such as "ll='ls -l'".
+func aliasDefWord(as *syntax.Assign) *syntax.Word {
+	if as.Name == nil {
+		return as.Value
+	}
+	parts := []syntax.WordPart{as.Name}
+	if !as.Naked {
+		parts = append(parts, &syntax.Lit{Value: "="})
+	}
+	if as.Value != nil {
+		parts = append(parts, as.Value.Parts...)
+	}
+	return &syntax.Word{Parts: parts}
+}
+
 func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 	if r.stop(ctx) {
 		return
@@ -339,6 +406,14 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		for k := range r.cmdVars {
 			delete(r.cmdVars, k)
 		}
+	case *syntax.TestCmd:
+		r.call(ctx, x.Args[0].Pos(), r.fields(x.Args...))
+	case *syntax.AliasClause:
+		args := []*syntax.Word{{Parts: []syntax.WordPart{x.Alias}}}
+		for _, def := range x.Defs {
+			args = append(args, aliasDefWord(def))
+		}
+		r.call(ctx, x.Pos(), r.fields(args...))
 	case *syntax.BinaryCmd:
 		switch x.Op {
 		case syntax.AndStmt, syntax.OrStmt:
@@ -432,6 +507,9 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 	case *syntax.LetClause:
 		var val int
 		for _, expr := range x.Exprs {
+			if expr = r.letExpr(expr); expr == nil {
+				return
+			}
 			val = r.arithm(expr)
 		}
 		r.exit = oneIf(val == 0)
@@ -453,6 +531,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		}
 	case *syntax.DeclClause:
 		local, global := false, false
+		funcs, unexport := false, false
 		var modes []string
 		valType := ""
 		switch x.Variant.Value {
@@ -481,10 +560,19 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 					switch name {
 					case "-x", "-r":
 						modes = append(modes, name)
-					case "-a", "-A", "-n":
+					case "-n":
+						if x.Variant.Value == "export" {
+							// "export -n" removes the export attribute
+							unexport = true
+						} else {
+							valType = name
+						}
+					case "-a", "-A":
 						valType = name
 					case "-g":
 						global = true
+					case "-f":
+						funcs = true
 					default:
 						r.errf("declare: invalid option %q\n", name)
 						r.exit = 2
@@ -492,6 +580,15 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 					}
 					continue
 				}
+				if funcs {
+					// Functions cannot be exported to other
+					// programs, so only check that they exist.
+					if r.Funcs[name] == nil {
+						r.errf("%s: %s: not a function\n", x.Variant.Value, name)
+						r.exit = 1
+					}
+					continue
+				}
 				if !syntax.ValidName(name) {
 					r.errf("declare: invalid name %q\n", name)
 					r.exit = 1
@@ -511,6 +608,9 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 						vr.ReadOnly = true
 					}
 				}
+				if unexport {
+					vr.Exported = false
+				}
 				if as.Naked {
 					r.setVarInternal(name, vr)
 				} else {
@@ -646,8 +746,8 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 			*orig = r.stderr
 		}
 		return nil, nil
-	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut,
-		syntax.RdrAll, syntax.AppAll:
+	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut, syntax.ClbOut,
+		syntax.RdrInOut, syntax.RdrAll, syntax.AppAll:
 		// done further below
 	// case syntax.DplIn:
 	default:
@@ -657,8 +757,21 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 	switch rd.Op {
 	case syntax.AppOut, syntax.AppAll:
 		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-	case syntax.RdrOut, syntax.RdrAll:
+	case syntax.RdrOut, syntax.ClbOut, syntax.RdrAll:
+		// There is no noclobber option, so >| is the same as >.
 		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case syntax.RdrInOut:
+		mode = os.O_RDWR | os.O_CREATE
+		if rd.N != nil {
+			switch rd.N.Value {
+			case "0", "1", "2":
+			default:
+				// only the standard streams can be redirected
+				err := fmt.Errorf("unsupported file descriptor: %s", rd.N.Value)
+				r.errf("%v\n", err)
+				return nil, err
+			}
+		}
 	}
 	f, err := r.open(ctx, arg, mode, 0644, true)
 	if err != nil {
@@ -667,7 +780,13 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 	switch rd.Op {
 	case syntax.RdrIn:
 		r.stdin = f
-	case syntax.RdrOut, syntax.AppOut:
+	case syntax.RdrInOut:
+		if rd.N == nil || rd.N.Value == "0" {
+			r.stdin = f
+		} else { // 1 or 2, checked above
+			*orig = f
+		}
+	case syntax.RdrOut, syntax.AppOut, syntax.ClbOut:
 		*orig = f
 	case syntax.RdrAll, syntax.AppAll:
 		r.stdout = f
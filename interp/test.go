@@ -41,7 +41,14 @@ func (r *Runner) bashTest(ctx context.Context, expr syntax.TestExpr, classic boo
 			}
 			return ""
 		}
-		if r.binTest(x.Op, r.bashTest(ctx, x.X, classic), r.bashTest(ctx, x.Y, classic)) {
+		var y string
+		if x.Op == syntax.TsReMatch {
+			// quoted parts of the regex match literally
+			y = r.regexp(x.Y.(*syntax.Word))
+		} else {
+			y = r.bashTest(ctx, x.Y, classic)
+		}
+		if r.binTest(x.Op, r.bashTest(ctx, x.X, classic), y) {
 			return "1"
 		}
 		return ""
@@ -243,6 +243,14 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 	}
 	if as.Value != nil {
 		s := r.literal(as.Value)
+		if as.Append && as.Index != nil {
+			// Append to the element, as setVar takes care of
+			// storing it at the index.
+			elem := r.literal(&syntax.Word{Parts: []syntax.WordPart{
+				&syntax.ParamExp{Param: as.Name, Index: as.Index},
+			}})
+			return expand.Variable{Kind: expand.String, Str: elem + s}
+		}
 		if !as.Append || !prev.IsSet() {
 			prev.Kind = expand.String
 			if valType == "-n" {
@@ -0,0 +1,218 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvalArithm evaluates an arithmetic expression using 64-bit integers, which
+// can be useful for constant folding or simple analysis without running a
+// shell. Unlike expand.Arithm, it never modifies any variables.
+//
+// Words must be numbers or variable names. Numbers can be decimal, octal like
+// "017", hexadecimal like "0xff", or in any base from 2 to 64 like "2#1010".
+// The values of variables are obtained via lookup, and using a variable when
+// lookup is nil is an error.
+//
+// The operators with side effects, which are assignments like "x = 3" or
+// "x += 2" and increments like "x++" or "--x", are not supported and result in
+// an error. As in shells, && and || short-circuit, and only one of the two
+// branches of a ternary operator is evaluated.
+func EvalArithm(x ArithmExpr, lookup func(name string) int64) (int64, error) {
+	switch x := x.(type) {
+	case *Word:
+		val := x.Lit()
+		if val == "" {
+			return 0, fmt.Errorf("cannot evaluate a non-literal word")
+		}
+		if ValidName(val) {
+			if lookup == nil {
+				return 0, fmt.Errorf("cannot evaluate variable %s", val)
+			}
+			return lookup(val), nil
+		}
+		n, ok := arithmNumber(val)
+		if !ok {
+			return 0, fmt.Errorf("invalid number: %s", val)
+		}
+		return n, nil
+	case *ParenArithm:
+		return EvalArithm(x.X, lookup)
+	case *UnaryArithm:
+		if x.Op == Inc || x.Op == Dec {
+			return 0, fmt.Errorf("cannot evaluate %s without side effects", x.Op)
+		}
+		val, err := EvalArithm(x.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case Not:
+			return evalBool(val == 0), nil
+		case BitNegation:
+			return ^val, nil
+		case Plus:
+			return val, nil
+		default: // Minus
+			return -val, nil
+		}
+	case *BinaryArithm:
+		switch x.Op {
+		case Assgn, AddAssgn, SubAssgn, MulAssgn, QuoAssgn, RemAssgn,
+			AndAssgn, OrAssgn, XorAssgn, ShlAssgn, ShrAssgn:
+			return 0, fmt.Errorf("cannot evaluate %s without side effects", x.Op)
+		case TernQuest: // TernColon is always its right side
+			cond, err := EvalArithm(x.X, lookup)
+			if err != nil {
+				return 0, err
+			}
+			b2 := x.Y.(*BinaryArithm)
+			if cond != 0 {
+				return EvalArithm(b2.X, lookup)
+			}
+			return EvalArithm(b2.Y, lookup)
+		}
+		left, err := EvalArithm(x.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case x.Op == AndArit && left == 0:
+			return 0, nil
+		case x.Op == OrArit && left != 0:
+			return 1, nil
+		}
+		right, err := EvalArithm(x.Y, lookup)
+		if err != nil {
+			return 0, err
+		}
+		return evalBinArithm(x.Op, left, right)
+	default:
+		panic(fmt.Sprintf("unexpected arithm expr: %T", x))
+	}
+}
+
+func evalBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func evalBinArithm(op BinAritOperator, x, y int64) (int64, error) {
+	switch op {
+	case Add:
+		return x + y, nil
+	case Sub:
+		return x - y, nil
+	case Mul:
+		return x * y, nil
+	case Quo, Rem:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == Quo {
+			return x / y, nil
+		}
+		return x % y, nil
+	case Pow:
+		if y < 0 {
+			return 0, fmt.Errorf("exponent less than 0")
+		}
+		p := int64(1)
+		for ; y > 0; y >>= 1 {
+			if y&1 != 0 {
+				p *= x
+			}
+			x *= x
+		}
+		return p, nil
+	case Eql:
+		return evalBool(x == y), nil
+	case Gtr:
+		return evalBool(x > y), nil
+	case Lss:
+		return evalBool(x < y), nil
+	case Neq:
+		return evalBool(x != y), nil
+	case Leq:
+		return evalBool(x <= y), nil
+	case Geq:
+		return evalBool(x >= y), nil
+	case And:
+		return x & y, nil
+	case Or:
+		return x | y, nil
+	case Xor:
+		return x ^ y, nil
+	case Shr:
+		return x >> uint64(y&63), nil
+	case Shl:
+		return x << uint64(y&63), nil
+	case AndArit:
+		return evalBool(x != 0 && y != 0), nil
+	case OrArit:
+		return evalBool(x != 0 || y != 0), nil
+	default: // Comma
+		return y, nil
+	}
+}
+
+// arithmNumber parses a number literal in an arithmetic expression, which may
+// be octal, hexadecimal, or in an arbitrary base as "base#digits". Like in
+// shells, numbers which are too large wrap around.
+func arithmNumber(val string) (int64, bool) {
+	base, digits := 10, val
+	if i := strings.IndexByte(val, '#'); i >= 0 {
+		n, err := strconv.Atoi(val[:i])
+		if err != nil || n < 2 || n > 64 {
+			return 0, false
+		}
+		base, digits = n, val[i+1:]
+	} else if strings.HasPrefix(val, "0x") || strings.HasPrefix(val, "0X") {
+		base, digits = 16, val[2:]
+	} else if len(val) > 1 && val[0] == '0' {
+		base, digits = 8, val[1:]
+	}
+	if digits == "" {
+		return 0, false
+	}
+	var n int64
+	for _, r := range digits {
+		d, ok := baseDigit(r, base)
+		if !ok {
+			return 0, false
+		}
+		n = n*int64(base) + int64(d)
+	}
+	return n, true
+}
+
+// baseDigit returns the value of a digit in an arithmetic base between 2 and
+// 64. Like in Bash, letters are case-insensitive up to base 36. Beyond that,
+// lowercase letters come first, followed by uppercase letters, "@", and "_".
+func baseDigit(r rune, base int) (int, bool) {
+	var d int
+	switch {
+	case '0' <= r && r <= '9':
+		d = int(r - '0')
+	case 'a' <= r && r <= 'z':
+		d = int(r-'a') + 10
+	case 'A' <= r && r <= 'Z':
+		d = int(r-'A') + 10
+		if base > 36 {
+			d += 26
+		}
+	case r == '@':
+		d = 62
+	case r == '_':
+		d = 63
+	default:
+		return 0, false
+	}
+	return d, d < base
+}
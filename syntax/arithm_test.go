@@ -0,0 +1,76 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEvalArithm(t *testing.T) {
+	t.Parallel()
+	vars := map[string]int64{"x": 3, "y": -2}
+	lookup := func(name string) int64 { return vars[name] }
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr string
+	}{
+		{in: "1+2*3", want: 7},
+		{in: "(1+2)*3", want: 9},
+		{in: "x>0?1:0", want: 1},
+		{in: "y>0?1:0", want: 0},
+		{in: "x>0 ? (y>0 ? 1 : 2) : 3", want: 2},
+		{in: "unset + 1", want: 1},
+		{in: "(2**10 - 7/2) - 7%2", want: 1020},
+		{in: "((-x + +y) - ~0) + (!0 + !x)", want: -3},
+		{in: "1<<4 | 1>>1 ^ 6&3", want: 18},
+		{in: "1<2 && 2<=2 && 3>=4 || 5!=5 || 1==1", want: 1},
+		{in: "0 && 1/0", want: 0},
+		{in: "1 || 1/0", want: 1},
+		{in: "1, 2, x", want: 3},
+		{in: "017 + 0xff + 0XA + 2#1010 + 16#fF + 64#_", want: 15 + 255 + 10 + 10 + 255 + 63},
+		{in: "1/0", wantErr: "division by zero"},
+		{in: "x%(y+2)", wantErr: "division by zero"},
+		{in: "2**-1", wantErr: "exponent less than 0"},
+		{in: "08", wantErr: "invalid number: 08"},
+		{in: "2#3", wantErr: "invalid number: 2#3"},
+		{in: "x = 1", wantErr: "cannot evaluate = without side effects"},
+		{in: "x += 1", wantErr: "cannot evaluate += without side effects"},
+		{in: "x++", wantErr: "cannot evaluate ++ without side effects"},
+		{in: "--x", wantErr: "cannot evaluate -- without side effects"},
+		{in: "$x + 1", wantErr: "cannot evaluate a non-literal word"},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			x, err := p.Arithmetic(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := EvalArithm(x, lookup)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("want error %q in %q, got %d, %v", tc.wantErr, tc.in, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("want %d in %q, got %d", tc.want, tc.in, got)
+			}
+		})
+	}
+	x, err := p.Arithmetic(strings.NewReader("x + 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "cannot evaluate variable x"
+	if _, err := EvalArithm(x, nil); err == nil || err.Error() != want {
+		t.Fatalf("want error %q with a nil lookup, got %v", want, err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+type builtinInfo struct {
+	special bool
+	lang    LangVariant
+}
+
+// builtins lists the shell builtins that the parser's language variants know
+// about. The special builtins are exactly those listed by POSIX in the "Special
+// Built-In Utilities" section of the Shell Command Language.
+var builtins = map[string]builtinInfo{
+	// POSIX special builtins
+	"break":    {special: true, lang: LangPOSIX},
+	":":        {special: true, lang: LangPOSIX},
+	"continue": {special: true, lang: LangPOSIX},
+	".":        {special: true, lang: LangPOSIX},
+	"eval":     {special: true, lang: LangPOSIX},
+	"exec":     {special: true, lang: LangPOSIX},
+	"exit":     {special: true, lang: LangPOSIX},
+	"export":   {special: true, lang: LangPOSIX},
+	"readonly": {special: true, lang: LangPOSIX},
+	"return":   {special: true, lang: LangPOSIX},
+	"set":      {special: true, lang: LangPOSIX},
+	"shift":    {special: true, lang: LangPOSIX},
+	"times":    {special: true, lang: LangPOSIX},
+	"trap":     {special: true, lang: LangPOSIX},
+	"unset":    {special: true, lang: LangPOSIX},
+
+	// POSIX regular builtins, which include the intrinsic utilities and
+	// those that all common shells implement as builtins
+	"[":       {lang: LangPOSIX},
+	"alias":   {lang: LangPOSIX},
+	"bg":      {lang: LangPOSIX},
+	"cd":      {lang: LangPOSIX},
+	"command": {lang: LangPOSIX},
+	"echo":    {lang: LangPOSIX},
+	"false":   {lang: LangPOSIX},
+	"fc":      {lang: LangPOSIX},
+	"fg":      {lang: LangPOSIX},
+	"getopts": {lang: LangPOSIX},
+	"hash":    {lang: LangPOSIX},
+	"jobs":    {lang: LangPOSIX},
+	"kill":    {lang: LangPOSIX},
+	"printf":  {lang: LangPOSIX},
+	"pwd":     {lang: LangPOSIX},
+	"read":    {lang: LangPOSIX},
+	"test":    {lang: LangPOSIX},
+	"true":    {lang: LangPOSIX},
+	"type":    {lang: LangPOSIX},
+	"ulimit":  {lang: LangPOSIX},
+	"umask":   {lang: LangPOSIX},
+	"unalias": {lang: LangPOSIX},
+	"wait":    {lang: LangPOSIX},
+
+	// Bash builtins
+	"bind":      {lang: LangBash},
+	"builtin":   {lang: LangBash},
+	"caller":    {lang: LangBash},
+	"compgen":   {lang: LangBash},
+	"complete":  {lang: LangBash},
+	"compopt":   {lang: LangBash},
+	"declare":   {lang: LangBash},
+	"dirs":      {lang: LangBash},
+	"disown":    {lang: LangBash},
+	"enable":    {lang: LangBash},
+	"help":      {lang: LangBash},
+	"history":   {lang: LangBash},
+	"let":       {lang: LangBash},
+	"local":     {lang: LangBash},
+	"logout":    {lang: LangBash},
+	"mapfile":   {lang: LangBash},
+	"popd":      {lang: LangBash},
+	"pushd":     {lang: LangBash},
+	"readarray": {lang: LangBash},
+	"shopt":     {lang: LangBash},
+	"source":    {lang: LangBash},
+	"suspend":   {lang: LangBash},
+	"typeset":   {lang: LangBash},
+}
+
+// IsBuiltin reports whether name is a builtin command in any of the supported
+// shell language variants, such as "cd" or "declare".
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// IsSpecialBuiltin reports whether name is one of the special builtins defined
+// by POSIX, such as "set" or ":". Unlike regular builtins, these are found
+// before functions, and their variable assignments persist after they finish.
+func IsSpecialBuiltin(name string) bool {
+	return builtins[name].special
+}
+
+// BuiltinLang returns the minimum language variant in which name is a builtin,
+// which is LangPOSIX for the builtins required by POSIX and LangBash for those
+// only found in Bash. If name isn't a builtin, false is returned.
+func BuiltinLang(name string) (LangVariant, bool) {
+	info, ok := builtins[name]
+	return info.lang, ok
+}
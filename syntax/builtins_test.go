@@ -0,0 +1,65 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import "testing"
+
+func TestSpecialBuiltins(t *testing.T) {
+	t.Parallel()
+	// from the "Special Built-In Utilities" section of POSIX
+	posixSpecial := []string{
+		"break", ":", "continue", ".", "eval", "exec", "exit", "export",
+		"readonly", "return", "set", "shift", "times", "trap", "unset",
+	}
+	special := 0
+	for name := range builtins {
+		if IsSpecialBuiltin(name) {
+			special++
+		}
+	}
+	if special != len(posixSpecial) {
+		t.Fatalf("want %d special builtins, got %d", len(posixSpecial), special)
+	}
+	for _, name := range posixSpecial {
+		if !IsBuiltin(name) || !IsSpecialBuiltin(name) {
+			t.Errorf("%q should be a special builtin", name)
+		}
+		if lang, _ := BuiltinLang(name); lang != LangPOSIX {
+			t.Errorf("%q should be a POSIX builtin, got %s", name, lang)
+		}
+	}
+}
+
+func TestBuiltinLang(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		builtin bool
+		special bool
+		lang    LangVariant
+	}{
+		{"set", true, true, LangPOSIX},
+		{"cd", true, false, LangPOSIX},
+		{"[", true, false, LangPOSIX},
+		{"declare", true, false, LangBash},
+		{"source", true, false, LangBash},
+		{"mapfile", true, false, LangBash},
+		{"ls", false, false, LangBash},
+		{"if", false, false, LangBash},
+		{"", false, false, LangBash},
+	}
+	for _, tc := range tests {
+		if got := IsBuiltin(tc.name); got != tc.builtin {
+			t.Errorf("IsBuiltin(%q) = %t, want %t", tc.name, got, tc.builtin)
+		}
+		if got := IsSpecialBuiltin(tc.name); got != tc.special {
+			t.Errorf("IsSpecialBuiltin(%q) = %t, want %t", tc.name, got, tc.special)
+		}
+		lang, ok := BuiltinLang(tc.name)
+		if ok != tc.builtin || (ok && lang != tc.lang) {
+			t.Errorf("BuiltinLang(%q) = %s, %t; want %s, %t",
+				tc.name, lang, ok, tc.lang, tc.builtin)
+		}
+	}
+}
@@ -114,6 +114,37 @@ func ExampleWalk() {
 	// Output: echo $FOO "and $BAR"
 }
 
+func ExampleApply() {
+	in := strings.NewReader("echo foo; if true; then echo $(echo bar); fi")
+	f, err := syntax.NewParser().Parse(in, "")
+	if err != nil {
+		return
+	}
+	// Rename all calls to echo into calls to print.
+	syntax.Apply(f, nil, func(c *syntax.Cursor) bool {
+		call, ok := c.Node().(*syntax.CallExpr)
+		if !ok || call.Args[0].Lit() != "echo" {
+			return true
+		}
+		// Keep the positions, so that the printer keeps the same layout.
+		old := call.Args[0]
+		name := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{
+			ValuePos: old.Pos(),
+			ValueEnd: old.End(),
+			Value:    "print",
+		}}}
+		c.Replace(&syntax.CallExpr{
+			Assigns: call.Assigns,
+			Args:    append([]*syntax.Word{name}, call.Args[1:]...),
+		})
+		return true
+	})
+	syntax.NewPrinter().Print(os.Stdout, f)
+	// Output:
+	// print foo
+	// if true; then print $(print bar); fi
+}
+
 func ExampleDebugPrint() {
 	in := strings.NewReader(`echo 'foo'`)
 	f, err := syntax.NewParser().Parse(in, "")
@@ -124,6 +155,8 @@ func ExampleDebugPrint() {
 	// Output:
 	// *syntax.File {
 	// .  Name: ""
+	// .  Shebang: ""
+	// .  HeaderComments: []syntax.Comment (len = 0) {}
 	// .  Stmts: []*syntax.Stmt (len = 1) {
 	// .  .  0: *syntax.Stmt {
 	// .  .  .  Comments: []syntax.Comment (len = 0) {}
@@ -150,6 +183,7 @@ func ExampleDebugPrint() {
 	// .  .  .  .  .  .  }
 	// .  .  .  .  .  }
 	// .  .  .  .  }
+	// .  .  .  .  Keyword: false
 	// .  .  .  }
 	// .  .  .  Position: 1:1
 	// .  .  .  Semicolon: 0:0
@@ -157,8 +191,12 @@ func ExampleDebugPrint() {
 	// .  .  .  Background: false
 	// .  .  .  Coprocess: false
 	// .  .  .  Redirs: []*syntax.Redirect (len = 0) {}
+	// .  .  .  BlankLines: 0
+	// .  .  .  Negations: 0
 	// .  .  }
 	// .  }
 	// .  Last: []syntax.Comment (len = 0) {}
+	// .  NoTrailingNewline: false
+	// .  TrailingBlankLines: 0
 	// }
 }
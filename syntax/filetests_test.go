@@ -167,6 +167,37 @@ var fileTests = []testCase{
 		Strs:   []string{"foo", "foo \\\n"},
 		common: litWord("foo"),
 	},
+	{
+		Strs:   []string{":", ": ", ":;"},
+		common: litWord(":"),
+	},
+	{
+		Strs:   []string{": ignored args", ":  ignored \\\n args"},
+		common: litCall(":", "ignored", "args"),
+	},
+	{
+		Strs:   []string{"true", "true;"},
+		common: litWord("true"),
+	},
+	{
+		Strs:   []string{"eval cmd", "eval cmd;"},
+		common: litCall("eval", "cmd"),
+	},
+	{
+		Strs:   []string{`eval "a; b"`},
+		common: call(litWord("eval"), word(dblQuoted(lit("a; b")))),
+	},
+	{
+		Strs:   []string{"eval a b c"},
+		common: litCall("eval", "a", "b", "c"),
+	},
+	{
+		Strs: []string{"eval foo\nbar", "eval foo; bar"},
+		common: []*Stmt{
+			litStmt("eval", "foo"),
+			litStmt("bar"),
+		},
+	},
 	{
 		Strs:   []string{"foo'bar'"},
 		common: word(lit("foo"), sglQuoted("bar")),
@@ -179,6 +210,20 @@ var fileTests = []testCase{
 		Strs:   []string{"(\n\tfoo\n\tbar\n)", "(foo; bar)"},
 		common: subshell(litStmt("foo"), litStmt("bar")),
 	},
+	{
+		Strs: []string{"( (echo hi))", "( (echo hi) )", "(\n\t(echo hi)\n)"},
+		common: subshell(stmt(
+			subshell(litStmt("echo", "hi")),
+		)),
+	},
+	{
+		Strs: []string{"((1 + 1))", "(( 1+1 ))"},
+		bsmk: arithmCmd(&BinaryArithm{
+			Op: Add,
+			X:  litWord("1"),
+			Y:  litWord("1"),
+		}),
+	},
 	{
 		Strs:   []string{"{ foo; }", "{\nfoo\n}"},
 		common: block(litStmt("foo")),
@@ -279,6 +324,33 @@ var fileTests = []testCase{
 		}),
 		posix: subshell(stmt(subshell(litStmt("a", "==", "2")))),
 	},
+	{
+		Strs: []string{"((i++))", "(( i++ ))"},
+		bsmk: arithmCmd(&UnaryArithm{
+			Op:   Inc,
+			Post: true,
+			X:    litWord("i"),
+		}),
+	},
+	{
+		Strs: []string{
+			"((a = b + c)) && echo ok",
+			"(( a = b + c )) && echo ok",
+		},
+		bsmk: &BinaryCmd{
+			Op: AndStmt,
+			X: stmt(arithmCmd(&BinaryArithm{
+				Op: Assgn,
+				X:  litWord("a"),
+				Y: &BinaryArithm{
+					Op: Add,
+					X:  litWord("b"),
+					Y:  litWord("c"),
+				},
+			})),
+			Y: litStmt("echo", "ok"),
+		},
+	},
 	{
 		Strs: []string{"if (($# > 2)); then b; fi"},
 		bsmk: &IfClause{
@@ -585,6 +657,26 @@ var fileTests = []testCase{
 		Strs:   []string{`""`},
 		common: dblQuoted(),
 	},
+	{
+		Strs:   []string{`''`},
+		common: sglQuoted(""),
+	},
+	{
+		Strs: []string{`x='' y=""`},
+		common: &CallExpr{Assigns: []*Assign{
+			{Name: lit("x"), Value: word(sglQuoted(""))},
+			{Name: lit("y"), Value: word(dblQuoted())},
+		}},
+	},
+	{
+		Strs: []string{`echo ''"" "" ''`},
+		common: call(
+			litWord("echo"),
+			word(sglQuoted(""), dblQuoted()),
+			word(dblQuoted()),
+			word(sglQuoted("")),
+		),
+	},
 	{
 		Strs:   []string{"=a s{s s=s"},
 		common: litCall("=a", "s{s", "s=s"),
@@ -705,6 +797,65 @@ var fileTests = []testCase{
 			litStmt("bar"),
 		},
 	},
+	{
+		Strs: []string{"a |& b && c", "a|&b&&c"},
+		bash: &BinaryCmd{
+			Op: AndStmt,
+			X: stmt(&BinaryCmd{
+				Op: PipeAll,
+				X:  litStmt("a"),
+				Y:  litStmt("b"),
+			}),
+			Y: litStmt("c"),
+		},
+		mksh: []*Stmt{
+			{Cmd: litCall("a"), Coprocess: true},
+			stmt(&BinaryCmd{
+				Op: AndStmt,
+				X:  litStmt("b"),
+				Y:  litStmt("c"),
+			}),
+		},
+	},
+	{
+		Strs: []string{"a && b |& c", "a&&b|&c"},
+		bash: &BinaryCmd{
+			Op: AndStmt,
+			X:  litStmt("a"),
+			Y: stmt(&BinaryCmd{
+				Op: PipeAll,
+				X:  litStmt("b"),
+				Y:  litStmt("c"),
+			}),
+		},
+		mksh: []*Stmt{
+			{
+				Cmd: &BinaryCmd{
+					Op: AndStmt,
+					X:  litStmt("a"),
+					Y:  litStmt("b"),
+				},
+				Coprocess: true,
+			},
+			litStmt("c"),
+		},
+	},
+	{
+		Strs: []string{"a |& b || c | d"},
+		bash: &BinaryCmd{
+			Op: OrStmt,
+			X: stmt(&BinaryCmd{
+				Op: PipeAll,
+				X:  litStmt("a"),
+				Y:  litStmt("b"),
+			}),
+			Y: stmt(&BinaryCmd{
+				Op: Pipe,
+				X:  litStmt("c"),
+				Y:  litStmt("d"),
+			}),
+		},
+	},
 	{
 		Strs: []string{
 			"foo() {\n\ta\n\tb\n}",
@@ -1044,7 +1195,7 @@ var fileTests = []testCase{
 		}),
 	},
 	{
-		Strs: []string{"$(<foo)", "`<foo`"},
+		Strs: []string{"$(<foo)", "$(< foo)", "$( <foo )", "`<foo`"},
 		common: cmdSubst(&Stmt{
 			Redirs: []*Redirect{{
 				Op:   RdrIn,
@@ -1052,6 +1203,18 @@ var fileTests = []testCase{
 			}},
 		}),
 	},
+	{
+		Strs: []string{`x="$(<foo)"`, `x="$(< foo)"`},
+		common: &CallExpr{Assigns: []*Assign{{
+			Name: lit("x"),
+			Value: word(dblQuoted(cmdSubst(&Stmt{
+				Redirs: []*Redirect{{
+					Op:   RdrIn,
+					Word: litWord("foo"),
+				}},
+			}))),
+		}}},
+	},
 	{
 		Strs: []string{"foo <<EOF >f\nbar\nEOF"},
 		common: &Stmt{
@@ -1412,7 +1575,7 @@ var fileTests = []testCase{
 		},
 	},
 	{
-		Strs: []string{"foo >|bar"},
+		Strs: []string{"foo >|bar", "foo >| bar", "foo>|bar"},
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{
@@ -1420,6 +1583,25 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{"echo hi 2>|file >|file2"},
+		common: &Stmt{
+			Cmd: litCall("echo", "hi"),
+			Redirs: []*Redirect{
+				{Op: ClbOut, N: lit("2"), Word: litWord("file")},
+				{Op: ClbOut, Word: litWord("file2")},
+			},
+		},
+	},
+	{
+		Strs: []string{"exec 3<>file", "exec 3<> file"},
+		common: &Stmt{
+			Cmd: litCall("exec"),
+			Redirs: []*Redirect{
+				{Op: RdrInOut, N: lit("3"), Word: litWord("file")},
+			},
+		},
+	},
 	{
 		Strs: []string{
 			"foo <<<input",
@@ -1469,6 +1651,51 @@ var fileTests = []testCase{
 			}},
 		},
 	},
+	{
+		Strs: []string{"mapfile -t arr < <(cmd)"},
+		bash: &Stmt{
+			Cmd: litCall("mapfile", "-t", "arr"),
+			Redirs: []*Redirect{{
+				Op: RdrIn,
+				Word: word(&ProcSubst{
+					Op:    CmdIn,
+					Stmts: litStmts("cmd"),
+				}),
+			}},
+		},
+	},
+	{
+		Strs: []string{"readarray -t lines < <(grep -v '^#' file | sort)"},
+		bash: &Stmt{
+			Cmd: litCall("readarray", "-t", "lines"),
+			Redirs: []*Redirect{{
+				Op: RdrIn,
+				Word: word(&ProcSubst{
+					Op: CmdIn,
+					Stmts: []*Stmt{stmt(&BinaryCmd{
+						Op: Pipe,
+						X: stmt(call(
+							litWord("grep"),
+							litWord("-v"),
+							word(sglQuoted("^#")),
+							litWord("file"),
+						)),
+						Y: litStmt("sort"),
+					})},
+				}),
+			}},
+		},
+	},
+	{
+		Strs: []string{`readarray arr <<<"$data"`, `readarray arr <<< "$data"`},
+		bash: &Stmt{
+			Cmd: litCall("readarray", "arr"),
+			Redirs: []*Redirect{{
+				Op:   WordHdoc,
+				Word: word(dblQuoted(litParamExp("data"))),
+			}},
+		},
+	},
 	{
 		Strs: []string{"a<(b) c>(d)"},
 		bash: call(
@@ -1491,6 +1718,47 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{"exec {log}>out.txt {x}>>a {y}<&- {z}<>f"},
+		bash: &Stmt{
+			Cmd: litCall("exec"),
+			Redirs: []*Redirect{
+				{Op: RdrOut, N: lit("{log}"), Word: litWord("out.txt")},
+				{Op: AppOut, N: lit("{x}"), Word: litWord("a")},
+				{Op: DplIn, N: lit("{y}"), Word: litWord("-")},
+				{Op: RdrInOut, N: lit("{z}"), Word: litWord("f")},
+			},
+		},
+	},
+	{
+		Strs: []string{"exec 3>&- 4<&- >&2", "exec 3>&-   4<&- >& 2"},
+		common: &Stmt{
+			Cmd: litCall("exec"),
+			Redirs: []*Redirect{
+				{Op: DplOut, N: lit("3"), Word: litWord("-")},
+				{Op: DplIn, N: lit("4"), Word: litWord("-")},
+				{Op: DplOut, Word: litWord("2")},
+			},
+		},
+	},
+	{
+		Strs: []string{"echo {fd} >f", "echo {fd} > f"},
+		common: &Stmt{
+			Cmd: litCall("echo", "{fd}"),
+			Redirs: []*Redirect{
+				{Op: RdrOut, Word: litWord("f")},
+			},
+		},
+	},
+	{
+		Strs: []string{"echo {1} >f", "echo {1}>f"},
+		common: &Stmt{
+			Cmd: litCall("echo", "{1}"),
+			Redirs: []*Redirect{
+				{Op: RdrOut, Word: litWord("f")},
+			},
+		},
+	},
 	{
 		Strs: []string{"! foo"},
 		common: &Stmt{
@@ -1498,6 +1766,30 @@ var fileTests = []testCase{
 			Cmd:     litCall("foo"),
 		},
 	},
+	{
+		Strs: []string{"! ! foo", "!\t!  foo"},
+		bsmk: &Stmt{Negations: 2, Cmd: litCall("foo")},
+	},
+	{
+		Strs: []string{"! ! ! foo | bar"},
+		bsmk: &Stmt{
+			Negated:   true,
+			Negations: 3,
+			Cmd: &BinaryCmd{
+				Op: Pipe,
+				X:  litStmt("foo"),
+				Y:  litStmt("bar"),
+			},
+		},
+	},
+	{
+		Strs: []string{"foo && ! ! ! bar"},
+		bsmk: &BinaryCmd{
+			Op: AndStmt,
+			X:  litStmt("foo"),
+			Y:  &Stmt{Negated: true, Negations: 3, Cmd: litCall("bar")},
+		},
+	},
 	{
 		Strs: []string{"foo &\nbar", "foo & bar", "foo&bar"},
 		common: []*Stmt{
@@ -1533,6 +1825,42 @@ var fileTests = []testCase{
 			Y: litStmt("bar"),
 		},
 	},
+	{
+		Strs: []string{"foo && ! bar", "foo &&\n! bar"},
+		common: &BinaryCmd{
+			Op: AndStmt,
+			X:  litStmt("foo"),
+			Y: &Stmt{
+				Cmd:     litCall("bar"),
+				Negated: true,
+			},
+		},
+	},
+	{
+		Strs: []string{"! foo || ! bar"},
+		common: &BinaryCmd{
+			Op: OrStmt,
+			X: &Stmt{
+				Cmd:     litCall("foo"),
+				Negated: true,
+			},
+			Y: &Stmt{
+				Cmd:     litCall("bar"),
+				Negated: true,
+			},
+		},
+	},
+	{
+		Strs:   []string{"echo ! !foo a!"},
+		common: litCall("echo", "!", "!foo", "a!"),
+	},
+	{
+		Strs: []string{"[[ ! -f x ]]"},
+		bsmk: &TestClause{X: &UnaryTest{
+			Op: TsNot,
+			X:  &UnaryTest{Op: TsRegFile, X: litWord("x")},
+		}},
+	},
 	{
 		Strs: []string{"! foo | bar"},
 		common: &Stmt{
@@ -1597,9 +1925,17 @@ var fileTests = []testCase{
 		)),
 	},
 	{
-		Strs:   []string{"$()"},
+		Strs:   []string{"$()", "$( )", "$(\t)", "``", "` `"},
 		common: cmdSubst(),
 	},
+	{
+		Strs: []string{`echo "$()" a$()b`, "echo \"``\" a``b"},
+		common: call(
+			litWord("echo"),
+			word(dblQuoted(cmdSubst())),
+			word(lit("a"), cmdSubst(), lit("b")),
+		),
+	},
 	{
 		Strs: []string{"()"},
 		mksh: subshell(), // not common, as dash/bash wrongly error
@@ -1661,6 +1997,49 @@ var fileTests = []testCase{
 			word(cmdSubst(litStmt("foo", "bar"))),
 		))),
 	},
+	{
+		Strs: []string{
+			"$(echo $(echo $(date)))",
+			"`echo \\`echo \\\\\\`date\\\\\\`\\``",
+		},
+		common: cmdSubst(stmt(call(
+			litWord("echo"),
+			word(cmdSubst(stmt(call(
+				litWord("echo"),
+				word(cmdSubst(litStmt("date"))),
+			)))),
+		))),
+	},
+	{
+		Strs: []string{
+			"$(echo $x \\$y $(echo $z))",
+			"`echo \\$x \\\\\\$y \\`echo \\\\$z\\``",
+		},
+		common: cmdSubst(stmt(call(
+			litWord("echo"),
+			word(litParamExp("x")),
+			litWord(`\$y`),
+			word(cmdSubst(stmt(call(
+				litWord("echo"),
+				word(litParamExp("z")),
+			)))),
+		))),
+	},
+	{
+		Strs: []string{"$(echo a\\\\)", "`echo a\\\\`"},
+		common: cmdSubst(stmt(call(
+			litWord("echo"),
+			litWord(`a\\`),
+		))),
+	},
+	{
+		Strs: []string{"$(echo)date$()", "`echo `date``"},
+		common: word(
+			cmdSubst(litStmt("echo")),
+			lit("date"),
+			cmdSubst(),
+		),
+	},
 	{
 		Strs: []string{"$( (a) | b)"},
 		common: cmdSubst(
@@ -1850,6 +2229,15 @@ var fileTests = []testCase{
 			word(&ParamExp{Length: true, Param: lit("#")}),
 		),
 	},
+	{
+		Strs: []string{`${#} ${#x} ${#@} ${#*}`},
+		common: call(
+			word(&ParamExp{Param: lit("#")}),
+			word(&ParamExp{Length: true, Param: lit("x")}),
+			word(&ParamExp{Length: true, Param: lit("@")}),
+			word(&ParamExp{Length: true, Param: lit("*")}),
+		),
+	},
 	{
 		Strs:   []string{`${foo}`},
 		common: &ParamExp{Param: lit("foo")},
@@ -2363,6 +2751,24 @@ var fileTests = []testCase{
 			Param:  lit("foo"),
 		},
 	},
+	{
+		Strs: []string{`${#x} ${x#p} ${#arr[@]}`},
+		bsmk: call(
+			word(&ParamExp{Length: true, Param: lit("x")}),
+			word(&ParamExp{
+				Param: lit("x"),
+				Exp: &Expansion{
+					Op:   RemSmallPrefix,
+					Word: litWord("p"),
+				},
+			}),
+			word(&ParamExp{
+				Length: true,
+				Param:  lit("arr"),
+				Index:  litWord("@"),
+			}),
+		),
+	},
 	{
 		Strs: []string{`${%foo}`},
 		mksh: &ParamExp{
@@ -2871,6 +3277,14 @@ var fileTests = []testCase{
 		bsmk:  dblDQuoted(lit("foo")),
 		posix: word(lit("$"), dblQuoted(lit("foo"))),
 	},
+	{
+		Strs: []string{`echo $"hello" "hello"`},
+		bsmk: call(
+			litWord("echo"),
+			word(dblDQuoted(lit("hello"))),
+			word(dblQuoted(lit("hello"))),
+		),
+	},
 	{
 		Strs: []string{`$"foo$"`},
 		bsmk: dblDQuoted(lit("foo"), lit("$")),
@@ -2965,6 +3379,36 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{
+			`case $i in "a|b") ;; 'c|d' | e\|f) ;; $var | a | b) ;; esac`,
+			`case $i in ("a|b") ;; ('c|d'|e\|f) ;; ($var|a | b) esac`,
+		},
+		common: &CaseClause{
+			Word: word(litParamExp("i")),
+			Items: []*CaseItem{
+				{
+					Op:       Break,
+					Patterns: []*Word{word(dblQuoted(lit("a|b")))},
+				},
+				{
+					Op: Break,
+					Patterns: []*Word{
+						word(sglQuoted("c|d")),
+						litWord(`e\|f`),
+					},
+				},
+				{
+					Op: Break,
+					Patterns: []*Word{
+						word(litParamExp("var")),
+						litWord("a"),
+						litWord("b"),
+					},
+				},
+			},
+		},
+	},
 	{
 		Strs: []string{"case i in 1) a ;& 2) ;; esac"},
 		bsmk: &CaseClause{
@@ -3184,9 +3628,8 @@ var fileTests = []testCase{
 		},
 	},
 	{
-		Strs:  []string{"[[ a ]]"},
-		bsmk:  &TestClause{X: litWord("a")},
-		posix: litStmt("[[", "a", "]]"),
+		Strs: []string{"[[ a ]]"},
+		bsmk: &TestClause{X: litWord("a")},
 	},
 	{
 		Strs: []string{"[[ a ]]\nb"},
@@ -3248,6 +3691,30 @@ var fileTests = []testCase{
 			),
 		}},
 	},
+	{
+		Strs: []string{`[[ $x =~ ^[0-9]+$ ]]`},
+		bash: &TestClause{X: &BinaryTest{
+			Op: TsReMatch,
+			X:  word(litParamExp("x")),
+			Y:  word(lit("^[0-9]+"), lit("$")),
+		}},
+	},
+	{
+		Strs: []string{`[[ $x =~ "literal." ]]`},
+		bash: &TestClause{X: &BinaryTest{
+			Op: TsReMatch,
+			X:  word(litParamExp("x")),
+			Y:  word(dblQuoted(lit("literal."))),
+		}},
+	},
+	{
+		Strs: []string{`[[ $x =~ *.go ]]`},
+		bash: &TestClause{X: &BinaryTest{
+			Op: TsReMatch,
+			X:  word(litParamExp("x")),
+			Y:  litWord("*.go"),
+		}},
+	},
 	{
 		Strs: []string{`[[ a =~ foo"bar" ]]`},
 		bash: &TestClause{X: &BinaryTest{
@@ -3560,6 +4027,27 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs:  []string{"export -f myfunc"},
+		posix: litStmt("export", "-f", "myfunc"),
+		bsmk: &DeclClause{
+			Variant: lit("export"),
+			Args: []*Assign{
+				{Naked: true, Value: litWord("-f")},
+				{Naked: true, Name: lit("myfunc")},
+			},
+		},
+	},
+	{
+		Strs: []string{"declare -n r=v"},
+		bash: &DeclClause{
+			Variant: lit("declare"),
+			Args: []*Assign{
+				{Naked: true, Value: litWord("-n")},
+				{Name: lit("r"), Value: litWord("v")},
+			},
+		},
+	},
 	{
 		Strs: []string{"(local bar)"},
 		bsmk: subshell(stmt(&DeclClause{
@@ -3587,6 +4075,51 @@ var fileTests = []testCase{
 		},
 		posix: litStmt("export", "bar"),
 	},
+	{
+		Strs: []string{"export A=1 B=2"},
+		bsmk: &DeclClause{
+			Variant: lit("export"),
+			Args: []*Assign{
+				{Name: lit("A"), Value: litWord("1")},
+				{Name: lit("B"), Value: litWord("2")},
+			},
+		},
+		posix: litStmt("export", "A=1", "B=2"),
+	},
+	{
+		Strs: []string{"export -p"},
+		bsmk: &DeclClause{
+			Variant: lit("export"),
+			Args:    []*Assign{{Naked: true, Value: litWord("-p")}},
+		},
+		posix: litStmt("export", "-p"),
+	},
+	{
+		Strs: []string{"readonly X Y=$y"},
+		bsmk: &DeclClause{
+			Variant: lit("readonly"),
+			Args: []*Assign{
+				{Naked: true, Name: lit("X")},
+				{Name: lit("Y"), Value: word(litParamExp("y"))},
+			},
+		},
+		posix: call(
+			litWord("readonly"),
+			litWord("X"),
+			word(lit("Y="), litParamExp("y")),
+		),
+	},
+	{
+		Strs: []string{"typeset -i n=3"},
+		bsmk: &DeclClause{
+			Variant: lit("typeset"),
+			Args: []*Assign{
+				{Naked: true, Value: litWord("-i")},
+				{Name: lit("n"), Value: litWord("3")},
+			},
+		},
+		posix: litStmt("typeset", "-i", "n=3"),
+	},
 	{
 		Strs: []string{"readonly -n"},
 		bsmk: &DeclClause{
@@ -3665,6 +4198,37 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{`declare -A m=([key]=val ["k 2"]=v2 [k3]=)`},
+		bash: &DeclClause{
+			Variant: lit("declare"),
+			Args: []*Assign{
+				{Naked: true, Value: litWord("-A")},
+				{
+					Name: lit("m"),
+					Array: &ArrayExpr{Elems: []*ArrayElem{
+						{Index: litWord("key"), Value: litWord("val")},
+						{
+							Index: word(dblQuoted(lit("k 2"))),
+							Value: litWord("v2"),
+						},
+						{Index: litWord("k3")},
+					}},
+				},
+			},
+		},
+	},
+	{
+		Strs: []string{"a=([2]=x y [5]=z)"},
+		bash: &CallExpr{Assigns: []*Assign{{
+			Name: lit("a"),
+			Array: &ArrayExpr{Elems: []*ArrayElem{
+				{Index: litWord("2"), Value: litWord("x")},
+				{Value: litWord("y")},
+				{Index: litWord("5"), Value: litWord("z")},
+			}},
+		}}},
+	},
 	{
 		Strs: []string{"declare foo[a]="},
 		bash: &DeclClause{
@@ -3802,6 +4366,39 @@ var fileTests = []testCase{
 			litStmt("foo"),
 		},
 	},
+	{
+		Strs: []string{"time ! foo | bar", "time ! foo|bar"},
+		bash: &TimeClause{Stmt: &Stmt{
+			Negated: true,
+			Cmd: &BinaryCmd{
+				Op: Pipe,
+				X:  litStmt("foo"),
+				Y:  litStmt("bar"),
+			},
+		}},
+	},
+	{
+		Strs: []string{"time ! ! foo"},
+		bash: &TimeClause{Stmt: &Stmt{Negations: 2, Cmd: litCall("foo")}},
+	},
+	{
+		Strs: []string{"time -p ! foo"},
+		bash: &TimeClause{PosixFormat: true, Stmt: &Stmt{
+			Negated: true,
+			Cmd:     litCall("foo"),
+		}},
+	},
+	{
+		Strs: []string{"! time foo | bar"},
+		bsmk: &Stmt{
+			Negated: true,
+			Cmd: &TimeClause{Stmt: stmt(&BinaryCmd{
+				Op: Pipe,
+				X:  litStmt("foo"),
+				Y:  litStmt("bar"),
+			})},
+		},
+	},
 	{
 		Strs:   []string{"coproc foo bar"},
 		common: litStmt("coproc", "foo", "bar"),
@@ -3822,8 +4419,9 @@ var fileTests = []testCase{
 		},
 	},
 	{
-		Strs: []string{"coproc foo", "coproc foo;"},
-		bash: &CoprocClause{Stmt: litStmt("foo")},
+		Strs:   []string{"coproc foo", "coproc foo;"},
+		common: litStmt("coproc", "foo"),
+		bash:   &CoprocClause{Stmt: litStmt("foo")},
 	},
 	{
 		Strs: []string{"coproc { foo; }"},
@@ -3903,6 +4501,21 @@ var fileTests = []testCase{
 			},
 		),
 	},
+	{
+		Strs: []string{`let a=1 b=2`},
+		bsmk: letClause(
+			&BinaryArithm{Op: Assgn, X: litWord("a"), Y: litWord("1")},
+			&BinaryArithm{Op: Assgn, X: litWord("b"), Y: litWord("2")},
+		),
+	},
+	{
+		Strs: []string{`let "a = b" 'c < d' e++`},
+		bsmk: letClause(
+			word(dblQuoted(lit("a = b"))),
+			word(sglQuoted("c < d")),
+			&UnaryArithm{Op: Inc, Post: true, X: litWord("e")},
+		),
+	},
 	{
 		Strs: []string{
 			`let a=$(echo 3)`,
@@ -3982,6 +4595,27 @@ var fileTests = []testCase{
 		},
 		posix: litStmt("a+=1"),
 	},
+	{
+		Strs: []string{"a+=(x) s+=str a[0]+=1"},
+		bsmk: &CallExpr{Assigns: []*Assign{
+			{
+				Append: true,
+				Name:   lit("a"),
+				Array:  arrValues(litWord("x")),
+			},
+			{
+				Append: true,
+				Name:   lit("s"),
+				Value:  litWord("str"),
+			},
+			{
+				Append: true,
+				Name:   lit("a"),
+				Index:  litWord("0"),
+				Value:  litWord("1"),
+			},
+		}},
+	},
 	{
 		Strs: []string{"b+=(2 3)"},
 		bsmk: &CallExpr{Assigns: []*Assign{{
@@ -4014,6 +4648,34 @@ var fileTests = []testCase{
 			},
 		}},
 	},
+	{
+		Strs: []string{
+			"a[0]=x a[i + 1]+=y map[key]=v",
+			"a[0]=x a[i+1]+=y map[key]=v",
+		},
+		bsmk: &CallExpr{Assigns: []*Assign{
+			{
+				Name:  lit("a"),
+				Index: litWord("0"),
+				Value: litWord("x"),
+			},
+			{
+				Name:   lit("a"),
+				Append: true,
+				Index: &BinaryArithm{
+					Op: Add,
+					X:  litWord("i"),
+					Y:  litWord("1"),
+				},
+				Value: litWord("y"),
+			},
+			{
+				Name:  lit("map"),
+				Index: litWord("key"),
+				Value: litWord("v"),
+			},
+		}},
+	},
 	{
 		Strs:   []string{"*[i]=x"},
 		posix:  lit("*[i]=x"),
@@ -4220,6 +4882,60 @@ var fileTests = []testCase{
 
 // these don't have a canonical format with the same syntax tree
 var fileTestsNoPrint = []testCase{
+	// only tabs are stripped before the closing delimiter; the printer
+	// re-indents <<- heredoc bodies, so these can't round-trip
+	{
+		Strs: []string{"foo <<-EOF\n\tbar\n\t  baz\n  \tqux\n\t\tEOF"},
+		common: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{{
+				Op:   DashHdoc,
+				Word: litWord("EOF"),
+				Hdoc: litWord("\tbar\n\t  baz\n  \tqux\n\t\t"),
+			}},
+		},
+	},
+	{
+		Strs: []string{"foo <<-EOF\nbar\n  EOF\nEOF"},
+		common: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{{
+				Op:   DashHdoc,
+				Word: litWord("EOF"),
+				Hdoc: litWord("bar\n  EOF\n"),
+			}},
+		},
+	},
+	{
+		Strs: []string{"foo <<-'EOF'\n\tbar\n\t  baz\n\tEOF"},
+		common: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{{
+				Op:   DashHdoc,
+				Word: word(sglQuoted("EOF")),
+				Hdoc: litWord("\tbar\n\t  baz\n\t"),
+			}},
+		},
+	},
+	{
+		Strs: []string{"foo <<-'EOF'\nbar\n  EOF\nEOF"},
+		common: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{{
+				Op:   DashHdoc,
+				Word: word(sglQuoted("EOF")),
+				Hdoc: litWord("bar\n  EOF\n"),
+			}},
+		},
+	},
+	// POSIX has no arithmetic commands, so "((" always opens nested
+	// subshells; the printer adds the space that other shells require
+	{
+		Strs: []string{"((echo hi))", "((echo hi) )"},
+		posix: subshell(stmt(
+			subshell(litStmt("echo", "hi")),
+		)),
+	},
 	{
 		Strs:  []string{`$[foo]`},
 		posix: word(lit("$"), lit("[foo]")),
@@ -4236,6 +4952,14 @@ var fileTestsNoPrint = []testCase{
 			Y:  litWord("3"),
 		})),
 	},
+	{
+		Strs: []string{"echo $[1+2]", "echo $[ 1 + 2 ]"},
+		bash: call(litWord("echo"), word(arithmExpBr(&BinaryArithm{
+			Op: Add,
+			X:  litWord("1"),
+			Y:  litWord("2"),
+		}))),
+	},
 }
 
 func fullProg(v interface{}) *File {
@@ -4350,6 +5074,10 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 				// ended by whitespace
 			case regOps(rune(end)):
 				// ended by end character
+			case end == '\\' && strings.HasPrefix(strings.TrimLeft(src[endOff:], "\\"), "`"):
+				// ended by an escaped backquote
+			case strings.HasPrefix(src[endOff:], "\\\n"):
+				// ended by an escaped newline
 			case endOff > 0 && src[endOff-1] == ';':
 				// ended by semicolon
 			case endOff > 0 && src[endOff-1] == '&':
@@ -4386,6 +5114,8 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 				recurse(a.Name)
 			}
 			if a.Index != nil {
+				setPos(&a.Lbrack, "[")
+				setPos(&a.Rbrack, "]")
 				recurse(a.Index)
 			}
 			if a.Value != nil {
@@ -4665,6 +5395,10 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 		if x.OpPos.IsValid() {
 			setPos(&x.OpPos, x.Op.String(), "esac")
 		}
+		if x.Lparen.IsValid() {
+			setPos(&x.Lparen, "(")
+		}
+		setPos(&x.Rparen, ")")
 		recurse(x.Patterns)
 		recurse(x.Stmts)
 		recurse(x.Last)
@@ -4699,6 +5433,8 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 		}
 	case *ArrayElem:
 		if x.Index != nil {
+			setPos(&x.Lbrack, "[")
+			setPos(&x.Rbrack, "]")
 			recurse(x.Index)
 		}
 		if x.Value != nil {
@@ -0,0 +1,104 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build go1.18
+// +build go1.18
+
+package syntax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fuzzSeeds are tricky inputs to seed the fuzzer with, on top of all the
+// parser test inputs.
+var fuzzSeeds = []string{
+	"echo $(echo $(echo `echo $(foo)`))",
+	"foo <<EOF\n$(bar <<EOF2\nbaz\nEOF2\n)\nEOF",
+	"foo <<-'EOF' && bar <<EOF2\n\tx\n\tEOF\ny\nEOF2",
+	"echo $(( (1 + 2) * 3 ? x++ : --y, z <<= 4 ))",
+	"echo ${a[@]:1:2} ${#a[*]} ${!a[@]} ${a/#x/y} ${a,,} ${a@Q}",
+	"a=([x]=1 [y]=2 3) b+=(4) c[5]+=6 declare -A d=([k]=v)",
+	"[[ -f a && ( b =~ ^c$ || ! d -nt e ) ]]",
+	"case $x in a|b) ;; (c) ;& d) ;;& esac",
+	"coproc name { foo |& bar; } 2>&1 >|out <>rw",
+	"f() { local -a x=(1); }; function g { time ! h; }",
+}
+
+// FuzzParsePrint checks that any input either fails to parse, or can be
+// printed and parsed back without changes.
+//
+// The first byte of the input selects the parser and printer options:
+//
+//	 1: posix, not bash
+//	 2: mksh, not bash
+//	 4: keep comments
+//	 8: simplify
+//	16: indent with spaces
+//	32: binary next line
+//	64: switch case indent
+func FuzzParsePrint(f *testing.F) {
+	for _, in := range fuzzSeeds {
+		f.Add(uint8(0), in)
+	}
+	for _, c := range append(fileTests, fileTestsNoPrint...) {
+		var opts uint8
+		switch {
+		case c.Bash != nil:
+		case c.Posix != nil:
+			opts = 1
+		case c.MirBSDKorn != nil:
+			opts = 2
+		}
+		for _, in := range c.Strs {
+			f.Add(opts, in)
+		}
+	}
+	f.Fuzz(func(t *testing.T, opts uint8, src string) {
+		if strings.HasSuffix(src, "\\") {
+			// A trailing backslash is a literal, but the newline
+			// added when printing turns it into a line continuation.
+			return
+		}
+		parser := NewParser()
+		switch {
+		case opts&0x01 != 0:
+			Variant(LangPOSIX)(parser)
+		case opts&0x02 != 0:
+			Variant(LangMirBSDKorn)(parser)
+		}
+		KeepComments(opts&0x04 != 0)(parser)
+		prog, err := parser.Parse(strings.NewReader(src), "")
+		if err != nil {
+			return
+		}
+		if opts&0x08 != 0 {
+			Simplify(prog)
+		}
+		printer := NewPrinter()
+		if opts&0x10 != 0 {
+			Indent(4)(printer)
+		}
+		BinaryNextLine(opts&0x20 != 0)(printer)
+		SwitchCaseIndent(opts&0x40 != 0)(printer)
+
+		var buf bytes.Buffer
+		if err := printer.Print(&buf, prog); err != nil {
+			t.Fatalf("unexpected print error: %v", err)
+		}
+		printed := buf.String()
+		prog2, err := parser.Parse(strings.NewReader(printed), "")
+		if err != nil {
+			t.Fatalf("printed program does not parse: %v\n%s", err, printed)
+		}
+		buf.Reset()
+		if err := printer.Print(&buf, prog2); err != nil {
+			t.Fatalf("unexpected print error: %v", err)
+		}
+		if got := buf.String(); got != printed {
+			t.Fatalf("printing is not stable:\n%s\nversus:\n%s", printed, got)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+// MinLang reports the minimum shell language variant that a node requires, as
+// well as the nodes which use features outside of POSIX, in the order that Walk
+// visits them. This can be useful to check whether a script written for Bash
+// can also run on POSIX shells like dash.
+//
+// LangPOSIX is returned if no such nodes are found. Otherwise, LangBash is
+// returned, unless any of the nodes use features specific to mksh, such as
+// "${ stmts;}", in which case LangMirBSDKorn is returned.
+//
+// Note that some builtins such as local and declare are reported as Bash
+// features, as they are parsed as DeclClause nodes.
+func MinLang(node Node) (LangVariant, []Node) {
+	lang := LangPOSIX
+	var nodes []Node
+	Walk(node, func(node Node) bool {
+		if l := nodeLang(node); l != LangPOSIX {
+			nodes = append(nodes, node)
+			if lang != LangMirBSDKorn {
+				lang = l
+			}
+		}
+		return true
+	})
+	return lang, nodes
+}
+
+// nodeLang returns the language variant that a single node requires, without
+// considering any of its children.
+func nodeLang(node Node) LangVariant {
+	switch x := node.(type) {
+	case *TestClause, *LetClause, *CoprocClause, *CStyleLoop,
+		*ProcSubst, *ExtGlob, *BraceExp:
+		return LangBash
+	case *ArithmCmd:
+		if x.Unsigned {
+			return LangMirBSDKorn
+		}
+		return LangBash
+	case *ArithmExp:
+		if x.Unsigned {
+			return LangMirBSDKorn
+		}
+		if x.Bracket {
+			return LangBash
+		}
+	case *CmdSubst:
+		if x.TempFile || x.ReplyVar {
+			return LangMirBSDKorn
+		}
+	case *CaseItem:
+		switch x.Op {
+		case Fallthrough, Resume:
+			return LangBash
+		case ResumeKorn:
+			return LangMirBSDKorn
+		}
+	case *FuncDecl:
+		if x.RsrvWord {
+			return LangBash
+		}
+	case *ForClause:
+		if x.Select || x.Braces {
+			return LangBash
+		}
+	case *DeclClause:
+		switch x.Variant.Value {
+		case "export", "readonly":
+		default:
+			return LangBash
+		}
+	case *TimeClause:
+		// A POSIX shell can still run time as a program, as long
+		// as it's followed by a simple command.
+		if x.Stmt != nil {
+			_, call := x.Stmt.Cmd.(*CallExpr)
+			_, test := x.Stmt.Cmd.(*TestCmd)
+			if !(call || test) || x.Stmt.Negated || x.Stmt.Negations > 0 {
+				return LangBash
+			}
+		}
+	case *BinaryCmd:
+		if x.Op == PipeAll {
+			return LangBash
+		}
+	case *Redirect:
+		switch x.Op {
+		case WordHdoc, RdrAll, AppAll:
+			return LangBash
+		}
+		if x.N != nil && x.N.Value[0] == '{' {
+			return LangBash
+		}
+	case *Assign:
+		if x.Append || x.Index != nil || x.Array != nil {
+			return LangBash
+		}
+	case *SglQuoted:
+		if x.Dollar {
+			return LangBash
+		}
+	case *DblQuoted:
+		if x.Dollar {
+			return LangBash
+		}
+	case *ParamExp:
+		if x.Width {
+			return LangMirBSDKorn
+		}
+		if x.Excl || x.Index != nil || x.Slice != nil || x.Repl != nil ||
+			x.Names != 0 || (x.Exp != nil && x.Exp.Op >= UpperFirst) {
+			return LangBash
+		}
+	}
+	return LangPOSIX
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var minLangTests = []struct {
+	in    string
+	lang  LangVariant
+	nodes []string
+}{
+	{in: "", lang: LangPOSIX},
+	{
+		in: `#!/bin/sh
+set -eu
+foo() {
+	export PATH="$1:$PATH" OLD=${2:-x}
+	readonly N
+	for f in *.txt; do
+		case $f in
+		a* | b*) cat "$f" 2>&1 >|out <in ;;
+		*) echo ${#f} ${f%.txt} $((N + 1)) ;;
+		esac
+	done
+	time foo -x
+	cat <<-EOF
+		$(date)
+	EOF
+}`,
+		lang: LangPOSIX,
+	},
+	{
+		in: `#!/bin/bash
+function foo {
+	local x=$'\n' y+=1 z=(a b)
+	declare -A m
+	[[ -n $x ]] && ((y++))
+	diff <(a) >(b) &>/dev/null
+	echo ${x/a/b} ${x:1} ${!y} ${x^^} ${z[0]} @(a|b) $"msg"
+	cat <<<"$x" |& tee {fd}>f
+	for ((i = 0; i < 3; i++)); do :; done
+	select s in a b; do :; done
+	time { a; }
+	let z=1 $[1 + 2]
+	coproc b
+	case $x in a) ;& b) ;;& esac
+}`,
+		lang: LangBash,
+		nodes: []string{
+			"*syntax.FuncDecl",
+			"*syntax.DeclClause",
+			"*syntax.SglQuoted",
+			"*syntax.Assign",
+			"*syntax.Assign",
+			"*syntax.DeclClause",
+			"*syntax.TestClause",
+			"*syntax.ArithmCmd",
+			"*syntax.ProcSubst",
+			"*syntax.ProcSubst",
+			"*syntax.Redirect",
+			"*syntax.ParamExp",
+			"*syntax.ParamExp",
+			"*syntax.ParamExp",
+			"*syntax.ParamExp",
+			"*syntax.ParamExp",
+			"*syntax.ExtGlob",
+			"*syntax.DblQuoted",
+			"*syntax.BinaryCmd",
+			"*syntax.Redirect",
+			"*syntax.Redirect",
+			"*syntax.CStyleLoop",
+			"*syntax.ForClause",
+			"*syntax.TimeClause",
+			"*syntax.LetClause",
+			"*syntax.ArithmExp",
+			"*syntax.CoprocClause",
+			"*syntax.CaseItem",
+			"*syntax.CaseItem",
+		},
+	},
+	{
+		in:    "echo ${ foo;} ${%x}; case x in a) ;| esac; a[1]=b",
+		lang:  LangMirBSDKorn,
+		nodes: []string{"*syntax.CmdSubst", "*syntax.ParamExp", "*syntax.CaseItem", "*syntax.Assign"},
+	},
+}
+
+func TestMinLang(t *testing.T) {
+	t.Parallel()
+	for i, tc := range minLangTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			lang := LangBash
+			if strings.Contains(tc.in, "${ ") {
+				lang = LangMirBSDKorn
+			}
+			parser := NewParser(Variant(lang))
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotLang, nodes := MinLang(f)
+			if gotLang != tc.lang {
+				t.Errorf("want %s, got %s", tc.lang, gotLang)
+			}
+			var gotNodes []string
+			for _, node := range nodes {
+				gotNodes = append(gotNodes, fmt.Sprintf("%T", node))
+			}
+			if !reflect.DeepEqual(gotNodes, tc.nodes) {
+				t.Errorf("want nodes:\n%q\ngot:\n%q", tc.nodes, gotNodes)
+			}
+		})
+	}
+}
+
+func TestMinLangPOSIX(t *testing.T) {
+	t.Parallel()
+	// Any input which parses as POSIX, and also as Bash with the same
+	// syntax tree, should not require Bash.
+	posix := NewParser(Variant(LangPOSIX))
+	bash := NewParser()
+	for _, c := range fileTests {
+		if c.Posix == nil {
+			continue
+		}
+		for _, in := range c.Strs {
+			want, err := posix.Parse(strings.NewReader(in), "")
+			if err != nil {
+				continue
+			}
+			got, err := bash.Parse(strings.NewReader(in), "")
+			if err != nil {
+				continue
+			}
+			clearPosRecurse(t, in, want)
+			clearPosRecurse(t, in, got)
+			if !reflect.DeepEqual(want, got) {
+				continue
+			}
+			if lang, nodes := MinLang(got); lang != LangPOSIX {
+				t.Errorf("%q: want posix, got %s with %#v", in, lang, nodes)
+			}
+		}
+	}
+}
@@ -5,7 +5,9 @@ package syntax
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -58,6 +60,10 @@ func bquoteEscaped(b byte) bool {
 const escNewl rune = utf8.RuneSelf + 1
 
 func (p *Parser) rune() rune {
+	if p.r == utf8.RuneSelf {
+		// stay at the end of the input, without moving past it
+		return p.r
+	}
 	if p.r == '\n' || p.r == escNewl {
 		// p.r instead of b so that newline
 		// character positions don't have col 0.
@@ -65,7 +71,15 @@ func (p *Parser) rune() rune {
 		p.npos.col = 0
 	}
 	p.npos.col += p.w
-	bquotes := 0
+	if p.bquoteBsl > 0 {
+		// the rest of a run which was already unescaped
+		p.bquoteBsl--
+		if p.litBs != nil {
+			p.litBs = append(p.litBs, '\\')
+		}
+		p.w, p.r = 1, '\\'
+		return p.r
+	}
 retry:
 	if p.bsp < len(p.bs) {
 		if b := p.bs[p.bsp]; b < utf8.RuneSelf {
@@ -74,16 +88,21 @@ retry:
 				if p.r != '\\' && p.peekByte('\n') {
 					p.bsp++
 					p.w, p.r = 1, escNewl
+					p.escNewlCR = false
 					return escNewl
 				}
-				if p.openBquotes > 0 && bquotes < p.openBquotes &&
-					p.bsp < len(p.bs) && bquoteEscaped(p.bs[p.bsp]) {
-					bquotes++
+				if p.r != '\\' && p.peekCRLF() {
+					p.bsp += 2
+					p.w, p.r = 1, escNewl
+					p.escNewlCR = true
+					return escNewl
+				}
+				if p.openBquotes > 0 && p.unescapeBquoteRun() == 0 {
 					goto retry
 				}
 			}
 			if b == '`' {
-				p.lastBquoteEsc = bquotes
+				p.lastBquoteEsc, p.bquoteEsc = p.bquoteEsc, 0
 			}
 			if p.litBs != nil {
 				p.litBs = append(p.litBs, b)
@@ -106,9 +125,9 @@ retry:
 		}
 		p.w = uint16(w)
 	} else {
-		if p.r == utf8.RuneSelf {
-		} else if p.fill(); p.bs == nil {
+		if p.fill(); p.bs == nil {
 			p.bsp++
+			p.eofNewline = p.r == '\n' || p.r == escNewl
 			p.r = utf8.RuneSelf
 			p.w = 1
 		} else {
@@ -118,6 +137,57 @@ retry:
 	return p.r
 }
 
+// unescapeBquoteRun handles a run of backslashes within backquotes, whose first
+// backslash was just read. Each level of backquotes adds a layer of escaping,
+// where "\\", "\`", and "\$" stand for the second character. For example, the
+// backquotes in "\`" and "\\\`" start a second and a third nested level.
+//
+// The whole run is consumed, and replaced by the backslashes which remain once
+// all the layers are removed, the number of which is returned. If that is zero,
+// the run is simply skipped. Otherwise, the first remaining backslash is the
+// current rune, and the rest are returned by the following calls to p.rune.
+func (p *Parser) unescapeBquoteRun() int {
+	run := 1
+	for p.peekByte('\\') {
+		p.bsp++
+		run++
+	}
+	var next byte
+	if p.bsp < len(p.bs) {
+		next = p.bs[p.bsp]
+	}
+	keep := run
+	for i := 0; i < p.openBquotes; i++ {
+		if bquoteEscaped(next) {
+			keep /= 2 // an odd backslash escapes next
+		} else {
+			keep = (keep + 1) / 2 // an odd backslash is kept
+		}
+	}
+	if next == '`' {
+		// A backquote delimits the nested level after the first
+		// layer where it's preceded by an even number of backslashes,
+		// so that it's not escaped anymore.
+		esc := 0
+		for n := run; n%2 == 1; n /= 2 {
+			esc++
+		}
+		if esc <= p.openBquotes {
+			p.bquoteEsc = esc
+			if keep%2 == 1 {
+				// A backslash can't escape a closing backquote, so
+				// it's literal, like one at the end of the input.
+				// Double it so that it's not lexed as an escape.
+				keep++
+			}
+		}
+	}
+	if keep > 0 {
+		p.bquoteBsl = keep - 1
+	}
+	return keep
+}
+
 // fill reads more bytes from the input src into readBuf. Any bytes that
 // had not yet been used at the end of the buffer are slid into the
 // beginning of the buffer.
@@ -127,8 +197,15 @@ func (p *Parser) fill() {
 	copy(p.readBuf[:left], p.readBuf[p.bsp:])
 readAgain:
 	n, err := 0, p.readErr
+	if err == nil && p.ctx != nil {
+		err = p.ctx.Err()
+		p.readErr = err
+	}
 	if err == nil {
 		n, err = p.src.Read(p.readBuf[left:])
+		if p.maxSize > 0 && p.offs+left+n > p.maxSize {
+			n, err = p.maxSize-p.offs-left, errTooLarge
+		}
 		p.readErr = err
 	}
 	if n == 0 {
@@ -136,7 +213,17 @@ readAgain:
 			goto readAgain
 		}
 		// don't use p.errPass as we don't want to overwrite p.tok
-		if err != io.EOF {
+		if err == errTooLarge {
+			// not getPos, as p.offs already counts the bytes
+			// in p.bs, and the next rune wasn't read yet
+			pos := p.npos
+			pos.offs = p.startPos.offs + uint32(p.offs)
+			p.err = ParseError{
+				Filename: p.f.Name,
+				Pos:      pos,
+				Text:     fmt.Sprintf("input too large: over %d bytes", p.maxSize),
+			}
+		} else if err != io.EOF {
 			p.err = err
 		}
 		if left > 0 {
@@ -202,6 +289,10 @@ func (p *Parser) next() {
 	for p.r == escNewl {
 		p.rune()
 	}
+	p.valConts = nil
+	if p.tok != _Newl && p.tok != illegalTok {
+		p.newlines = 0
+	}
 	p.spaced = false
 	if p.quote&allKeepSpaces != 0 {
 		p.nextKeepSpaces()
@@ -222,6 +313,7 @@ skipSpace:
 		case '\n':
 			if p.tok == _Newl {
 				// merge consecutive newline tokens
+				p.newlines++
 				r = p.rune()
 				continue
 			}
@@ -255,15 +347,17 @@ skipSpace:
 			p.newLit(r)
 			for r != '\n' && r != utf8.RuneSelf {
 				if r == escNewl {
-					p.litBs = append(p.litBs, '\\', '\n')
+					p.litBs = append(p.litBs, p.escNewlSrc()...)
 					break
 				}
 				r = p.rune()
 			}
+			p.newlines = 0
 			if p.keepComments {
 				*p.curComs = append(*p.curComs, Comment{
 					Hash: p.pos,
-					Text: p.endLit(),
+					// a Windows line ending isn't part of the text
+					Text: strings.TrimSuffix(p.endLit(), "\r"),
 				})
 			} else {
 				p.litBs = nil
@@ -339,6 +433,22 @@ func (p *Parser) peekByte(b byte) bool {
 	return p.bsp < len(p.bs) && p.bs[p.bsp] == b
 }
 
+// escNewlSrc returns the source of the escaped newline in p.r.
+func (p *Parser) escNewlSrc() string {
+	if p.escNewlCR {
+		return "\\\r\n"
+	}
+	return "\\\n"
+}
+
+// peekCRLF reports whether the next two bytes are a Windows line ending.
+func (p *Parser) peekCRLF() bool {
+	if p.bsp+1 >= len(p.bs) {
+		p.fill()
+	}
+	return p.bsp+1 < len(p.bs) && p.bs[p.bsp] == '\r' && p.bs[p.bsp+1] == '\n'
+}
+
 func (p *Parser) regToken(r rune) token {
 	switch r {
 	case '\'':
@@ -770,6 +880,14 @@ func (p *Parser) endLit() (s string) {
 	return
 }
 
+// addContinuation records an escaped newline at the current end of the
+// literal being lexed; see Continuations.
+func (p *Parser) addContinuation() {
+	if p.conts != nil {
+		p.valConts = append(p.valConts, len(p.litBs))
+	}
+}
+
 func (p *Parser) isLitRedir() bool {
 	lit := p.litBs[:len(p.litBs)-1]
 	if lit[0] == '{' && lit[len(lit)-1] == '}' {
@@ -809,6 +927,8 @@ loop:
 		switch r {
 		case '\\': // escaped byte follows
 			p.rune()
+		case escNewl:
+			p.addContinuation()
 		case '"', '`', '$':
 			tok = _Lit
 			break loop
@@ -852,6 +972,8 @@ loop:
 			break loop
 		case '\\': // escaped byte follows
 			p.rune()
+		case escNewl:
+			p.addContinuation()
 		case '>', '<':
 			if p.peekByte('(') {
 				tok = _Lit
@@ -875,6 +997,7 @@ loop:
 		case '=':
 			if p.eqlOffs < 0 {
 				p.eqlOffs = len(p.litBs) - 1
+				p.eqlPos = p.getPos()
 			}
 		case '[':
 			if p.lang != LangPOSIX && len(p.litBs) > 1 && p.litBs[0] != '[' {
@@ -964,7 +1087,7 @@ func (p *Parser) quotedHdocWord() *Word {
 		lStart := len(p.litBs) - 1
 		for r != utf8.RuneSelf && r != '\n' {
 			if r == escNewl {
-				p.litBs = append(p.litBs, '\\', '\n')
+				p.litBs = append(p.litBs, p.escNewlSrc()...)
 				break
 			}
 			r = p.rune()
@@ -5,6 +5,8 @@ package syntax
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -23,13 +25,50 @@ type Node interface {
 type File struct {
 	Name string
 
-	Stmts []*Stmt
+	// Shebang is the "#!" line at the very start of the file, such as
+	// "#!/bin/sh", without the trailing newline. It is empty if the file
+	// has no such line.
+	Shebang string
+
+	// HeaderComments is the run of comments at the very start of the file,
+	// such as a license header, if a blank line separates it from the rest
+	// of the file. It is only set when parsing with KeepComments; otherwise,
+	// or without the blank line, the comments belong to the first statement.
+	HeaderComments []Comment
+
+	Stmts []*Stmt // in source order, as parsed
 	Last  []Comment
+
+	// NoTrailingNewline is true if the file did not end with a newline,
+	// and TrailingBlankLines is the number of blank lines at its end.
+	// Both are only set when parsing with KeepBlankLines, and the printer
+	// reproduces them.
+	NoTrailingNewline  bool
+	TrailingBlankLines int
 }
 
 func (f *File) Pos() Pos { return stmtsPos(f.Stmts, f.Last) }
 func (f *File) End() Pos { return stmtsEnd(f.Stmts, f.Last) }
 
+// StmtAt returns the top-level statement containing a position, from its
+// first character up to but excluding its end, or nil if there is none.
+// Comments and here-document bodies are not part of a statement's range.
+//
+// Since the statements are in source order, a binary search is used.
+func (f *File) StmtAt(pos Pos) *Stmt {
+	if !pos.IsValid() {
+		return nil
+	}
+	offs := pos.Offset()
+	i := sort.Search(len(f.Stmts), func(i int) bool {
+		return f.Stmts[i].End().Offset() > offs
+	})
+	if i < len(f.Stmts) && f.Stmts[i].Pos().Offset() <= offs {
+		return f.Stmts[i]
+	}
+	return nil
+}
+
 func stmtsPos(stmts []*Stmt, last []Comment) Pos {
 	if len(stmts) > 0 {
 		s := stmts[0]
@@ -65,6 +104,14 @@ func stmtsEnd(stmts []*Stmt, last []Comment) Pos {
 }
 
 // Pos is a position within a shell source file.
+//
+// Lines and columns start at 1, while byte offsets start at 0. Columns
+// count bytes, not characters. Since End is the position right after a
+// node, the source of a node n is src[n.Pos().Offset():n.End().Offset()],
+// without any adjustment; see NodeBytes.
+//
+// The zero value, Pos{}, is not a valid position. It is used for positions
+// which are unset, such as Stmt.Semicolon when there is no semicolon.
 type Pos struct {
 	offs      uint32
 	line, col uint16
@@ -93,6 +140,16 @@ func (p Pos) IsValid() bool { return p.line > 0 }
 // version of p.Offset() > p2.Offset().
 func (p Pos) After(p2 Pos) bool { return p.offs > p2.offs }
 
+// Advance returns the position n bytes after p, which may be negative to move
+// backwards. The bytes in between must not include any newlines, such as when
+// moving past a token. Invalid positions are returned as they are.
+func (p Pos) Advance(n int) Pos {
+	if !p.IsValid() {
+		return p
+	}
+	return posAddCol(p, n)
+}
+
 func posAddCol(p Pos, n int) Pos {
 	p.col += uint16(n)
 	p.offs += uint32(n)
@@ -106,6 +163,59 @@ func posMax(p1, p2 Pos) Pos {
 	return p1
 }
 
+// NodeBytes returns the bytes in src spanned by node, which must have been
+// parsed from src. It returns nil if the node's positions are invalid or
+// don't fit in src.
+func NodeBytes(src []byte, node Node) []byte {
+	pos, end := node.Pos(), node.End()
+	if !pos.IsValid() || !end.IsValid() {
+		return nil
+	}
+	start, stop := pos.Offset(), end.Offset()
+	if start > stop || stop > uint(len(src)) {
+		return nil
+	}
+	return src[start:stop]
+}
+
+// SourceMap maps byte offsets in parsed shell source back to a document the
+// source was extracted from. Unlike StartPos, which is enough for a snippet
+// copied as-is, a SourceMap supports snippets built from many pieces of the
+// document, such as a YAML block scalar with its indentation removed.
+//
+// The zero value is an empty map, which maps each offset to itself.
+type SourceMap struct {
+	segs []sourceSeg
+}
+
+type sourceSeg struct {
+	offs, docOffs uint
+}
+
+// Add records that the source bytes starting at offset were copied from the
+// document bytes starting at docOffset, up until the offset of the next call.
+// The offsets must be added in increasing order.
+func (m *SourceMap) Add(offset, docOffset uint) {
+	if n := len(m.segs); n > 0 && m.segs[n-1].offs >= offset {
+		panic("SourceMap offsets must be added in increasing order")
+	}
+	m.segs = append(m.segs, sourceSeg{offset, docOffset})
+}
+
+// DocOffset returns the byte offset in the document for a position in the
+// parsed source. Offsets before the first segment are returned unchanged.
+func (m *SourceMap) DocOffset(pos Pos) uint {
+	offs := pos.Offset()
+	i := sort.Search(len(m.segs), func(i int) bool {
+		return m.segs[i].offs > offs
+	})
+	if i == 0 {
+		return offs
+	}
+	seg := m.segs[i-1]
+	return seg.docOffs + (offs - seg.offs)
+}
+
 // Comment represents a single comment on a single line.
 type Comment struct {
 	Hash Pos
@@ -123,11 +233,22 @@ type Stmt struct {
 	Cmd        Command
 	Position   Pos
 	Semicolon  Pos  // position of ';', '&', or '|&', if any
-	Negated    bool // ! stmt
+	Negated    bool // ! stmt; see Negations
 	Background bool // stmt &
 	Coprocess  bool // mksh's |&
 
 	Redirs []*Redirect // stmt >a <b
+
+	// BlankLines is the number of blank lines directly before the
+	// statement, after any comments. It is only set when parsing with
+	// KeepBlankLines.
+	BlankLines int
+
+	// Negations is the number of "!" before the statement if there is
+	// more than one, such as 2 in "! ! stmt". Each "!" after the first
+	// toggles Negated, so "! ! stmt" is not negated, but its exit status
+	// is still made 0 or 1.
+	Negations int
 }
 
 func (s *Stmt) Pos() Pos { return s.Position }
@@ -157,7 +278,8 @@ func (s *Stmt) End() Pos {
 //
 // These are *CallExpr, *IfClause, *WhileClause, *ForClause, *CaseClause,
 // *Block, *Subshell, *BinaryCmd, *FuncDecl, *ArithmCmd, *TestClause,
-// *DeclClause, *LetClause, *TimeClause, and *CoprocClause.
+// *TestCmd, *AliasClause, *DeclClause, *LetClause, *TimeClause, and
+// *CoprocClause.
 type Command interface {
 	Node
 	commandNode()
@@ -174,6 +296,8 @@ func (*BinaryCmd) commandNode()    {}
 func (*FuncDecl) commandNode()     {}
 func (*ArithmCmd) commandNode()    {}
 func (*TestClause) commandNode()   {}
+func (*TestCmd) commandNode()      {}
+func (*AliasClause) commandNode()  {}
 func (*DeclClause) commandNode()   {}
 func (*LetClause) commandNode()    {}
 func (*TimeClause) commandNode()   {}
@@ -197,6 +321,8 @@ type Assign struct {
 	Index  ArithmExpr // [i], ["k"]
 	Value  *Word      // =val
 	Array  *ArrayExpr // =(arr)
+
+	Lbrack, Rbrack Pos // position of "[" and "]", if Index is set
 }
 
 func (a *Assign) Pos() Pos {
@@ -213,16 +339,24 @@ func (a *Assign) End() Pos {
 	if a.Array != nil {
 		return a.Array.End()
 	}
+	end := a.Name.End()
 	if a.Index != nil {
-		return posAddCol(a.Index.End(), 2)
+		end = posAddCol(a.Rbrack, 1)
 	}
 	if a.Naked {
-		return a.Name.End()
+		return end
 	}
-	return posAddCol(a.Name.End(), 1)
+	if a.Append {
+		return posAddCol(end, 2)
+	}
+	return posAddCol(end, 1)
 }
 
 // Redirect represents an input/output redirection.
+//
+// The positions of N, Op, and Word are kept separately, so any spacing
+// between them in the original source can be recovered. For example, "2>&1"
+// and "2>& 1" only differ in the position of Word.
 type Redirect struct {
 	OpPos Pos
 	Op    RedirOperator
@@ -245,6 +379,84 @@ func (r *Redirect) End() Pos {
 	return r.Word.End()
 }
 
+// DelimQuoted reports whether the redirect is a heredoc, either with Hdoc or
+// DashHdoc, whose delimiter word is quoted in any way, like <<'EOF', <<"EOF",
+// or <<\EOF. The body of such a heredoc is taken literally, so Hdoc then
+// consists of a single Lit without any expansions.
+func (r *Redirect) DelimQuoted() bool {
+	if r.Op != Hdoc && r.Op != DashHdoc {
+		return false
+	}
+	for _, wp := range r.Word.Parts {
+		switch x := wp.(type) {
+		case *SglQuoted, *DblQuoted:
+			return true
+		case *Lit:
+			if strings.IndexByte(x.Value, '\\') >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Fd returns the file descriptor the redirect applies to. If N is nil, the
+// default for the operator is used: 0 for input redirects like < and <<, and 1
+// for output redirects like > and >&. Note that &> and &>> apply to both 1 and
+// 2, and 1 is returned for them. The result is false if N is a Bash {varname},
+// as the descriptor is then only known at run time.
+func (r *Redirect) Fd() (int, bool) {
+	if r.N != nil {
+		n, err := strconv.Atoi(r.N.Value)
+		return n, err == nil
+	}
+	if r.IsInput() {
+		return 0, true
+	}
+	return 1, true
+}
+
+// IsAppend reports whether the redirect appends to a file, as with >> and &>>.
+func (r *Redirect) IsAppend() bool {
+	return r.Op == AppOut || r.Op == AppAll
+}
+
+// IsInput reports whether the redirect reads input, as with <, <>, <&, and
+// here-documents and here-strings.
+func (r *Redirect) IsInput() bool {
+	switch r.Op {
+	case RdrIn, RdrInOut, DplIn, Hdoc, DashHdoc, WordHdoc:
+		return true
+	}
+	return false
+}
+
+// IsOutput reports whether the redirect writes output, as with >, >>, >|, >&,
+// <>, &>, and &>>.
+func (r *Redirect) IsOutput() bool {
+	switch r.Op {
+	case RdrOut, AppOut, RdrInOut, DplOut, ClbOut, RdrAll, AppAll:
+		return true
+	}
+	return false
+}
+
+// IsDup reports whether the redirect duplicates or closes a file descriptor, as
+// with <& and >&.
+func (r *Redirect) IsDup() bool {
+	return r.Op == DplIn || r.Op == DplOut
+}
+
+// IsClose reports whether the redirect closes a file descriptor, as with <&-
+// and >&-.
+func (r *Redirect) IsClose() bool {
+	if !r.IsDup() || len(r.Word.Parts) != 1 {
+		return false
+	}
+	lit, ok := r.Word.Parts[0].(*Lit)
+	return ok && lit.Value == "-"
+}
+
 // CallExpr represents a command execution or function call, otherwise known as
 // a "simple command".
 //
@@ -253,6 +465,10 @@ func (r *Redirect) End() Pos {
 type CallExpr struct {
 	Assigns []*Assign // a=x b=y args
 	Args    []*Word
+
+	// Keyword is true if the first argument is one of the reserved words
+	// given to the parser via the ExtraKeywords option.
+	Keyword bool
 }
 
 func (c *CallExpr) Pos() Pos {
@@ -269,6 +485,45 @@ func (c *CallExpr) End() Pos {
 	return c.Args[len(c.Args)-1].End()
 }
 
+// IsEnvPrefix reports whether s is a simple command whose assignments only
+// apply to the environment of the command being called, such as
+// "foo=bar cmd". Standalone assignments such as "foo=bar" apply to the shell
+// environment instead, so they are not prefixes; neither are statements
+// without any assignments.
+func IsEnvPrefix(s *Stmt) bool {
+	call, ok := s.Cmd.(*CallExpr)
+	return ok && len(call.Assigns) > 0 && len(call.Args) > 0
+}
+
+// IsNoop reports whether s is a simple command which does nothing and
+// succeeds, such as ":", ": ignored args", or "true". This can be useful for
+// dead code analysis.
+//
+// Statements with assignments, redirections, or arguments containing any
+// expansions are not considered no-ops, as they may have side effects; for
+// example, ": ${foo:=bar}" assigns a default value to foo.
+func IsNoop(s *Stmt) bool {
+	call, ok := s.Cmd.(*CallExpr)
+	if !ok || len(call.Assigns) > 0 || len(call.Args) == 0 {
+		return false
+	}
+	if s.Negated || s.Negations > 0 || s.Background || s.Coprocess ||
+		len(s.Redirs) > 0 {
+		return false
+	}
+	switch call.Args[0].Lit() {
+	case ":", "true":
+	default:
+		return false
+	}
+	for _, w := range call.Args[1:] {
+		if literal, _, _ := WordKind(w); !literal {
+			return false
+		}
+	}
+	return true
+}
+
 // Subshell represents a series of commands that should be executed in a nested
 // shell environment.
 type Subshell struct {
@@ -403,6 +658,9 @@ func (f *FuncDecl) End() Pos { return f.Body.End() }
 // Word represents a shell word, containing one or more word parts contiguous to
 // each other. The word is delimeted by word boundaries, such as spaces,
 // newlines, semicolons, or parentheses.
+//
+// Each part ends where the next one starts, with the only exception of line
+// continuations between them. For example, "a"'b'c is three parts.
 type Word struct {
 	Parts []WordPart
 }
@@ -432,6 +690,31 @@ func (w *Word) Lit() string {
 	return strings.Join(lits, "")
 }
 
+// WordKind classifies a word by the expansions it contains, which is useful to
+// spot expansions subject to word splitting, like $foo in "cmd $foo".
+//
+// literal reports whether the word has no parameter expansions, command or
+// process substitutions, or arithmetic expansions at all, such as foo or 'a b'.
+// Otherwise, quotedExp reports whether any of the expansions are within double
+// quotes, and unquotedExp whether any of them aren't, meaning that their result
+// is split into fields. For example, a"$b"$c has both.
+func WordKind(w *Word) (literal, quotedExp, unquotedExp bool) {
+	for _, part := range w.Parts {
+		switch x := part.(type) {
+		case *ParamExp, *CmdSubst, *ArithmExp, *ProcSubst:
+			unquotedExp = true
+		case *DblQuoted:
+			for _, part := range x.Parts {
+				switch part.(type) {
+				case *ParamExp, *CmdSubst, *ArithmExp:
+					quotedExp = true
+				}
+			}
+		}
+	}
+	return !quotedExp && !unquotedExp, quotedExp, unquotedExp
+}
+
 // WordPart represents all nodes that can form part of a word.
 //
 // These are *Lit, *SglQuoted, *DblQuoted, *ParamExp, *CmdSubst, *ArithmExp,
@@ -455,7 +738,8 @@ func (*BraceExp) wordPartNode()  {}
 //
 // Note that a parsed string literal may not appear as-is in the original source
 // code, as it is possible to split literals by escaping newlines. The splitting
-// is lost, but the end position is not.
+// is lost, but the end position is not. Parse with Continuations to record
+// where the escaped newlines were.
 type Lit struct {
 	ValuePos, ValueEnd Pos
 	Value              string
@@ -464,6 +748,29 @@ type Lit struct {
 func (l *Lit) Pos() Pos { return l.ValuePos }
 func (l *Lit) End() Pos { return l.ValueEnd }
 
+// Unescaped returns the literal's value with its backslash escapes removed,
+// following the rules for unquoted words. For example, the literals "a\ b"
+// and "a\nb" become "a b" and "anb". A trailing backslash is kept as-is.
+//
+// Value is left untouched, as the printer needs the escapes. Note that within
+// double quotes and here-documents, backslashes only escape some characters,
+// so this method is not meant for literals found there.
+func (l *Lit) Unescaped() string {
+	if strings.IndexByte(l.Value, '\\') < 0 {
+		return l.Value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(l.Value); i++ {
+		b := l.Value[i]
+		if b == '\\' && i+1 < len(l.Value) {
+			i++
+			b = l.Value[i]
+		}
+		sb.WriteByte(b)
+	}
+	return sb.String()
+}
+
 // SglQuoted represents a string within single quotes.
 type SglQuoted struct {
 	Left, Right Pos
@@ -500,6 +807,12 @@ func (c *CmdSubst) Pos() Pos { return c.Left }
 func (c *CmdSubst) End() Pos { return posAddCol(c.Right, 1) }
 
 // ParamExp represents a parameter expansion.
+//
+// A leading '#' is only the length operator if a parameter follows it. That
+// is, ${#} is the number of positional parameters, represented with Param set
+// to "#" and Length unset, while ${#x} is the length of x. Note that ${#@} and
+// ${#*} also expand to the number of positional parameters, and are represented
+// as the length of "@" and "*" respectively.
 type ParamExp struct {
 	Dollar, Rbrace Pos
 
@@ -526,6 +839,24 @@ func (p *ParamExp) End() Pos {
 	return p.Param.End()
 }
 
+// AllElements returns "@" or "*" if the parameter expansion indexes all the
+// elements of an array, such as ${a[@]} or ${a[*]}. An empty string is returned
+// otherwise, including for ${a[0]} and for quoted indexes like ${a["@"]}.
+//
+// Like with "$@" and "$*", the two differ in how the elements are joined when
+// the expansion is quoted.
+func (p *ParamExp) AllElements() string {
+	w, ok := p.Index.(*Word)
+	if !ok {
+		return ""
+	}
+	switch lit := w.Lit(); lit {
+	case "@", "*":
+		return lit
+	}
+	return ""
+}
+
 func (p *ParamExp) nakedIndex() bool {
 	return p.Short && p.Index != nil
 }
@@ -663,6 +994,8 @@ func (c *CaseClause) End() Pos { return posAddCol(c.Esac, 4) }
 type CaseItem struct {
 	Op       CaseOperator
 	OpPos    Pos // unset if it was finished by "esac"
+	Lparen   Pos // unset if the patterns weren't preceded by "("
+	Rparen   Pos
 	Comments []Comment
 	Patterns []*Word
 
@@ -670,12 +1003,21 @@ type CaseItem struct {
 	Last  []Comment
 }
 
-func (c *CaseItem) Pos() Pos { return c.Patterns[0].Pos() }
+func (c *CaseItem) Pos() Pos {
+	if c.Lparen.IsValid() {
+		return c.Lparen
+	}
+	return c.Patterns[0].Pos()
+}
 func (c *CaseItem) End() Pos {
 	if c.OpPos.IsValid() {
 		return posAddCol(c.OpPos, len(c.Op.String()))
 	}
-	return stmtsEnd(c.Stmts, c.Last)
+	if end := stmtsEnd(c.Stmts, c.Last); end.IsValid() {
+		return end
+	}
+	// an empty last item like "a)" right before "esac"
+	return posAddCol(c.Rparen, 1)
 }
 
 // TestClause represents a Bash extended test clause.
@@ -734,12 +1076,57 @@ type ParenTest struct {
 func (p *ParenTest) Pos() Pos { return p.Lparen }
 func (p *ParenTest) End() Pos { return posAddCol(p.Rparen, 1) }
 
+// TestCmd represents a simple command which runs the "[" or "test" builtins,
+// such as "[ -f foo ]", with its operands structured as a test expression.
+//
+// Args holds all of the original arguments, including the command name and the
+// closing "]", so that the command can be printed and run as it was written.
+// X is formed by the same operand words, with the "-a" and "-o" operators as
+// AndTest and OrTest. X is nil if there are no operands, as in "[ ]".
+//
+// Walk and Apply only visit Args, as they include every word in X. A word
+// replaced via Apply is also replaced in X.
+//
+// This node will only appear with the TestCmds parser option.
+type TestCmd struct {
+	Args []*Word
+	X    TestExpr
+}
+
+func (t *TestCmd) Pos() Pos { return t.Args[0].Pos() }
+func (t *TestCmd) End() Pos { return t.Args[len(t.Args)-1].End() }
+
+// AliasClause represents a simple command which runs the "alias" builtin, such
+// as "alias ll='ls -l'", with its operands structured as alias definitions.
+//
+// Defs has one element for each of the operands, which is what gets printed and
+// run: a definition like "ll='ls -l'" has a Name and a Value, a name to print
+// like "ll" is naked with a Name, and any other operand such as "-p" is naked
+// with a Value. Alias names may contain characters like "." or "-", so Name is
+// not necessarily a valid variable name.
+//
+// This node will only appear with the AliasClauses parser option.
+type AliasClause struct {
+	Alias *Lit
+	Defs  []*Assign
+}
+
+func (a *AliasClause) Pos() Pos { return a.Alias.Pos() }
+func (a *AliasClause) End() Pos {
+	if len(a.Defs) > 0 {
+		return a.Defs[len(a.Defs)-1].End()
+	}
+	return a.Alias.End()
+}
+
 // DeclClause represents a Bash declare clause.
 //
 // Args can contain a mix of regular and naked assignments. The naked
 // assignments can represent either options or variable names.
 //
-// This node will only appear with LangBash.
+// This node will only appear with LangBash and LangMirBSDKorn, and the
+// "declare" variant only with LangBash. With LangPOSIX, builtins such as
+// "export" and "readonly" are parsed as regular commands.
 type DeclClause struct {
 	// Variant is one of "declare", "local", "export", "readonly",
 	// "typeset", or "nameref".
@@ -755,6 +1142,44 @@ func (d *DeclClause) End() Pos {
 	return d.Variant.End()
 }
 
+// HasOpt reports whether the clause has the given single-letter option
+// enabled, such as 'i' for "declare -i". Combined options like "-ix" are
+// understood, while options turned off like "+x" and options which aren't a
+// single literal like "-$flags" are not counted. Any arguments after "--"
+// are not options.
+func (d *DeclClause) HasOpt(opt byte) bool {
+	for _, as := range d.Args {
+		if as.Name != nil || as.Value == nil {
+			continue // not an option
+		}
+		val := as.Value.Lit()
+		if val == "--" {
+			break // end of options
+		}
+		if len(val) < 2 || val[0] != '-' {
+			continue
+		}
+		if strings.IndexByte(val[1:], opt) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNameref reports whether the clause declares name references, such as
+// "declare -n ref=target" or "nameref ref=target", where each variable refers
+// to the variable named by its value. Note that "export -n" is not a nameref,
+// as it removes the export attribute instead.
+func (d *DeclClause) IsNameref() bool {
+	switch d.Variant.Value {
+	case "nameref":
+		return true
+	case "declare", "local", "typeset":
+		return d.HasOpt('n')
+	}
+	return false
+}
+
 // ArrayExpr represents a Bash array expression.
 //
 // This node will only appear with LangBash.
@@ -774,6 +1199,8 @@ func (a *ArrayExpr) End() Pos { return posAddCol(a.Rparen, 1) }
 // Value can be nil; for example, declare -A x=([index]=).
 // Finally, neither can be nil; for example, declare -A x=([index]=value)
 type ArrayElem struct {
+	Lbrack, Rbrack Pos // position of "[" and "]", if Index is set
+
 	Index    ArithmExpr
 	Value    *Word
 	Comments []Comment
@@ -781,7 +1208,7 @@ type ArrayElem struct {
 
 func (a *ArrayElem) Pos() Pos {
 	if a.Index != nil {
-		return a.Index.Pos()
+		return a.Lbrack
 	}
 	return a.Value.Pos()
 }
@@ -790,7 +1217,7 @@ func (a *ArrayElem) End() Pos {
 	if a.Value != nil {
 		return a.Value.End()
 	}
-	return posAddCol(a.Index.Pos(), 1)
+	return posAddCol(a.Rbrack, 2) // "]="
 }
 
 // ExtGlob represents a Bash extended globbing expression. Note that these are
@@ -4,7 +4,9 @@
 package syntax
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -28,9 +30,9 @@ func TestPosition(t *testing.T) {
 					t.Fatalf("Unexpected error in %q: %v", in, err)
 				}
 				v := &posWalker{
-					t:     t,
-					f:     prog,
-					lines: strings.Split(in, "\n"),
+					t:   t,
+					f:   prog,
+					src: in,
 				}
 				Walk(prog, v.Visit)
 			})
@@ -39,9 +41,9 @@ func TestPosition(t *testing.T) {
 }
 
 type posWalker struct {
-	t     *testing.T
-	f     *File
-	lines []string
+	t   *testing.T
+	f   *File
+	src string
 }
 
 func (v *posWalker) Visit(n Node) bool {
@@ -60,9 +62,201 @@ func (v *posWalker) Visit(n Node) bool {
 			v.t.Fatalf("A Comment is after its File")
 		}
 	}
+	checkLineCol(v.t, v.src, n.Pos())
+	checkLineCol(v.t, v.src, n.End())
+	if w, ok := n.(*Word); ok {
+		checkContiguous(v.t, v.src, w.Parts)
+	}
+	if q, ok := n.(*DblQuoted); ok {
+		checkContiguous(v.t, v.src, q.Parts)
+	}
 	return true
 }
 
+// checkLineCol checks that the line and column of a position match its byte
+// offset in src. Backslashes are skipped, as escaped newlines and the escapes
+// dropped within backquotes don't advance the column like other bytes do.
+func checkLineCol(tb testing.TB, src string, pos Pos) {
+	tb.Helper()
+	if !pos.IsValid() || pos.Offset() > uint(len(src)) {
+		return
+	}
+	if strings.ContainsRune(src, '\\') {
+		return
+	}
+	before := src[:pos.Offset()]
+	line := uint(strings.Count(before, "\n")) + 1
+	col := uint(len(before) - strings.LastIndexByte(before, '\n'))
+	if pos.Line() != line || pos.Col() != col {
+		tb.Fatalf("position at offset %d should be %d:%d, got %s",
+			pos.Offset(), line, col, pos)
+	}
+}
+
+// checkContiguous checks that there are no gaps between adjacent word parts,
+// other than line continuations, so that concatenations like "a"'b'c can be
+// told apart from separate words.
+func checkContiguous(tb testing.TB, src string, parts []WordPart) {
+	tb.Helper()
+	for i := 1; i < len(parts); i++ {
+		end, pos := parts[i-1].End(), parts[i].Pos()
+		if end.Offset() > pos.Offset() {
+			tb.Fatalf("word part at %s overlaps with the previous one ending at %s", pos, end)
+		}
+		if gap := src[end.Offset():pos.Offset()]; strings.Trim(gap, "\\\n") != "" {
+			tb.Fatalf("gap %q between word parts at %s and %s", gap, end, pos)
+		}
+	}
+}
+
+func TestPositionMultibyte(t *testing.T) {
+	t.Parallel()
+	src := "x=ü\necho ñandú \"ö $x ${x:-à}\"\n\t'ß' # ç\nfoo() { ü; }\n"
+	f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &posWalker{t: t, f: f, src: src}
+	Walk(f, v.Visit)
+	Walk(f, func(node Node) bool {
+		if lit, ok := node.(*Lit); ok {
+			start, end := lit.Pos().Offset(), lit.End().Offset()
+			if got := src[start:end]; got != lit.Value {
+				t.Fatalf("Lit %q at %s spans %q", lit.Value, lit.Pos(), got)
+			}
+		}
+		return true
+	})
+}
+
+func TestPosAdvance(t *testing.T) {
+	t.Parallel()
+	src := "foo\nbar bazz"
+	f, err := NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := f.Stmts[1].Cmd.(*CallExpr).Args
+	bar, bazz := args[0].Pos(), args[1].Pos()
+	if got := bar.Advance(len("bar ")); got != bazz {
+		t.Fatalf("want %v, got %v", bazz, got)
+	}
+	if got := bazz.Advance(-len("bar ")); got != bar {
+		t.Fatalf("want %v, got %v", bar, got)
+	}
+	end := bazz.Advance(len("bazz"))
+	if end != args[1].End() {
+		t.Fatalf("want %v, got %v", args[1].End(), end)
+	}
+	if want := uint(len(src)); end.Offset() != want || end.Col() != 9 {
+		t.Fatalf("want offset %d and col 9, got %d and %d",
+			want, end.Offset(), end.Col())
+	}
+	if got := (Pos{}).Advance(3); got.IsValid() {
+		t.Fatalf("advancing an invalid position should not make it valid")
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	t.Parallel()
+	// A script in an indented YAML block, where each line of the
+	// script is indented by four spaces.
+	doc := "run: |\n    foo bar\n    if baz; then\n      qux\n    fi\n"
+	var src strings.Builder
+	var m SourceMap
+	for offs := strings.Index(doc, "foo"); offs < len(doc); {
+		line := doc[offs:]
+		line = line[:strings.IndexByte(line, '\n')+1]
+		m.Add(uint(src.Len()), uint(offs))
+		src.WriteString(line)
+		offs += len(line) + len("    ")
+	}
+	f, err := NewParser().Parse(strings.NewReader(src.String()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Walk(f, func(node Node) bool {
+		lit, ok := node.(*Lit)
+		if !ok {
+			return true
+		}
+		start, end := m.DocOffset(lit.Pos()), m.DocOffset(lit.End())
+		if got := doc[start:end]; got != lit.Value {
+			t.Errorf("%q at %s maps to %q in the document", lit.Value, lit.Pos(), got)
+		}
+		return true
+	})
+
+	var empty SourceMap
+	if got := empty.DocOffset(f.Pos().Advance(2)); got != 2 {
+		t.Errorf("an empty SourceMap should keep offsets, got %d", got)
+	}
+}
+
+func TestFileStmtAt(t *testing.T) {
+	t.Parallel()
+	src := "foo; bar baz\n\nif a; then b; fi\n# c\nqux"
+	f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		offs uint32
+		want int // index in f.Stmts, or -1 for nil
+	}{
+		{0, 0},
+		{3, 0}, // the semicolon
+		{4, -1},
+		{5, 1},
+		{11, 1},
+		{12, -1}, // the newline
+		{13, -1},
+		{14, 2},
+		{25, 2}, // the nested statement
+		{29, 2},
+		{30, -1},
+		{31, -1}, // the comment
+		{35, 3},
+		{37, 3},
+		{38, -1}, // the end of the input
+	}
+	for _, tc := range tests {
+		var want *Stmt
+		if tc.want >= 0 {
+			want = f.Stmts[tc.want]
+		}
+		// only the offset matters, but the position must be valid
+		pos := Pos{offs: tc.offs, line: 1, col: 1}
+		if got := f.StmtAt(pos); got != want {
+			t.Errorf("StmtAt(%d): want statement %d, got %v",
+				tc.offs, tc.want, got)
+		}
+	}
+	if got := f.StmtAt(Pos{}); got != nil {
+		t.Errorf("StmtAt with an invalid position should return nil")
+	}
+}
+
+func TestFileStmtsOrder(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	for _, c := range fileTests {
+		for _, in := range c.Strs {
+			f, err := p.Parse(strings.NewReader(in), "")
+			if err != nil {
+				continue
+			}
+			for i := 1; i < len(f.Stmts); i++ {
+				prev, cur := f.Stmts[i-1], f.Stmts[i]
+				if !cur.Pos().After(prev.Pos()) || prev.End().After(cur.Pos()) {
+					t.Fatalf("statements out of order in %q: %s then %s",
+						in, prev.Pos(), cur.Pos())
+				}
+			}
+		}
+	}
+}
+
 func TestWeirdOperatorString(t *testing.T) {
 	t.Parallel()
 	op := RedirOperator(1000)
@@ -71,3 +265,662 @@ func TestWeirdOperatorString(t *testing.T) {
 		t.Fatalf("token.String() mismatch: want %s, got %s", want, got)
 	}
 }
+
+func TestRedirectSpans(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in                string
+		nPos, op, wordPos uint
+	}{
+		{"foo 2>&1", 4, 5, 7},
+		{"foo 2>& 1", 4, 5, 8},
+		{"foo 2>&  1", 4, 5, 9},
+		{"foo >&1", 0, 4, 6},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := f.Stmts[0].Redirs[0]
+			if r.N != nil && r.N.Pos().Offset() != tc.nPos {
+				t.Fatalf("N offset in %q: want %d, got %d",
+					tc.in, tc.nPos, r.N.Pos().Offset())
+			}
+			if got := r.OpPos.Offset(); got != tc.op {
+				t.Fatalf("OpPos offset in %q: want %d, got %d",
+					tc.in, tc.op, got)
+			}
+			if got := r.Word.Pos().Offset(); got != tc.wordPos {
+				t.Fatalf("Word offset in %q: want %d, got %d",
+					tc.in, tc.wordPos, got)
+			}
+		})
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		typ  Node
+		want string
+	}{
+		{"if a; then b; fi", &IfClause{}, "if a; then b; fi"},
+		{"if a; then b; else c; fi", &IfClause{}, "if a; then b; else c; fi"},
+		{"while a; do b; done", &WhileClause{}, "while a; do b; done"},
+		{"until a\ndo b\ndone", &WhileClause{}, "until a\ndo b\ndone"},
+		{"for i in 1 2; do b; done", &ForClause{}, "for i in 1 2; do b; done"},
+		{"for i in 1 2; do b; done", &WordIter{}, "i in 1 2"},
+		{"for ((i=0; i<2; i++)); do b; done", &CStyleLoop{}, "((i=0; i<2; i++))"},
+		{"select i in 1; do b; done", &ForClause{}, "select i in 1; do b; done"},
+		{"case x in a) b ;; esac", &CaseClause{}, "case x in a) b ;; esac"},
+		{"case x in a) b ;; esac", &CaseItem{}, "a) b ;;"},
+		{"case x in esac", &CaseClause{}, "case x in esac"},
+		{"{ a; }", &Block{}, "{ a; }"},
+		{"{ a\n}", &Block{}, "{ a\n}"},
+		{"(a)", &Subshell{}, "(a)"},
+		{"f() { a; }", &FuncDecl{}, "f() { a; }"},
+		{"function f { a; }", &FuncDecl{}, "function f { a; }"},
+		{"a | b", &BinaryCmd{}, "a | b"},
+		{"a && b || c", &BinaryCmd{}, "a && b || c"},
+		{"[[ -f a && ( b || c ) ]]", &TestClause{}, "[[ -f a && ( b || c ) ]]"},
+		{"[[ -f a && ( b || c ) ]]", &UnaryTest{}, "-f a"},
+		{"[[ -f a && ( b || c ) ]]", &ParenTest{}, "( b || c )"},
+		{"((a + b))", &ArithmCmd{}, "((a + b))"},
+		{"echo $(( (1) ))", &ParenArithm{}, "(1)"},
+		{"let a++", &LetClause{}, "let a++"},
+		{"time a", &TimeClause{}, "time a"},
+		{"coproc a", &CoprocClause{}, "coproc a"},
+		{"declare -a x=(1 2)", &DeclClause{}, "declare -a x=(1 2)"},
+		{"x=(1 2)", &ArrayExpr{}, "(1 2)"},
+		{"x=([a]=b)", &ArrayElem{}, "[a]=b"},
+		{"x=([a]=)", &ArrayElem{}, "[a]="},
+		{"x=", &Assign{}, "x="},
+		{"x+=", &Assign{}, "x+="},
+		{"x[1]+=", &Assign{}, "x[1]+="},
+		{"declare x[1]", &Assign{}, "x[1]"},
+		{"a=([ 1 ]=x)", &ArrayElem{}, "[ 1 ]=x"},
+		{"a=([ 1 ]=)", &ArrayElem{}, "[ 1 ]="},
+		{"a=([ 1 \\\n]=)", &ArrayElem{}, "[ 1 \\\n]="},
+		{"a[ 1 ]=", &Assign{}, "a[ 1 ]="},
+		{"declare a[ 1 ]", &Assign{}, "a[ 1 ]"},
+		{"declare a[1\\\n]", &Assign{}, "a[1\\\n]"},
+		{"echo $(a)", &CmdSubst{}, "$(a)"},
+		{"echo `a`", &CmdSubst{}, "`a`"},
+		{"echo <(a)", &ProcSubst{}, "<(a)"},
+		{"echo $((1 + 2))", &ArithmExp{}, "$((1 + 2))"},
+		{"echo ${a:-b}", &ParamExp{}, "${a:-b}"},
+		{`echo "$a"`, &DblQuoted{}, `"$a"`},
+		{"echo $'a'", &SglQuoted{}, "$'a'"},
+		{"echo @(a|b)", &ExtGlob{}, "@(a|b)"},
+		{"a >f", &Redirect{}, ">f"},
+		{"a <<EOF\nb\nEOF", &Redirect{}, "<<EOF\nb\nEOF"},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := reflect.TypeOf(tc.typ)
+			var node Node
+			Walk(f, func(n Node) bool {
+				if node == nil && reflect.TypeOf(n) == want {
+					node = n
+				}
+				return node == nil
+			})
+			if node == nil {
+				t.Fatalf("no %T found in %q", tc.typ, tc.in)
+			}
+			got := tc.in[node.Pos().Offset():node.End().Offset()]
+			if got != tc.want {
+				t.Fatalf("%T span in %q: want %q, got %q",
+					tc.typ, tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWordPartsContiguous(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		parts int
+	}{
+		{`"a"'b'c`, 3},
+		{`a$b"c"${d}$(e)'f'`, 6},
+		{`$'a'$"b"` + "`c`" + `$((1))d`, 5},
+		{`"a$b"c`, 2},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			w := f.Stmts[0].Cmd.(*CallExpr).Args[0]
+			if len(w.Parts) != tc.parts {
+				t.Fatalf("want %d parts in %q, got %d", tc.parts, tc.in, len(w.Parts))
+			}
+			checkContiguous(t, tc.in, w.Parts)
+			if got := w.End().Offset(); got != uint(len(tc.in)) {
+				t.Fatalf("want word to end at offset %d, got %d", len(tc.in), got)
+			}
+		})
+	}
+}
+
+func TestWordPartSpans(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`$x"y"`, []string{`$x`, `"y"`}},
+		{`$x'y'`, []string{`$x`, `'y'`}},
+		{`"y"$x`, []string{`"y"`, `$x`}},
+		{`a$x"y"b`, []string{`a`, `$x`, `"y"`, `b`}},
+		{`${x}"y"`, []string{`${x}`, `"y"`}},
+		{`$x$y"z"`, []string{`$x`, `$y`, `"z"`}},
+		{`$1"y"`, []string{`$1`, `"y"`}},
+		{`$@"y"$#`, []string{`$@`, `"y"`, `$#`}},
+		{`$x-"y"`, []string{`$x`, `-`, `"y"`}},
+		{`$x""`, []string{`$x`, `""`}},
+		{`"$x"$y`, []string{`"$x"`, `$y`}},
+		{`$x$'y'$"z"`, []string{`$x`, `$'y'`, `$"z"`}},
+		{`$(a)"y"$((1))`, []string{`$(a)`, `"y"`, `$((1))`}},
+		{"`a`\"y\"$x", []string{"`a`", `"y"`, `$x`}},
+		{`$x\"y`, []string{`$x`, `\"y`}},
+		{`$x[0]"y"`, []string{`$x`, `[0]`, `"y"`}},
+		{`${x[0]}"y"`, []string{`${x[0]}`, `"y"`}},
+		{`$é"y"`, []string{`$`, `é`, `"y"`}},
+		{`é$x"ü"`, []string{`é`, `$x`, `"ü"`}},
+		{"$x\\\n\"y\"", []string{"$x\\\n", `"y"`}},
+		{"\"y\"\\\n$x\\\nz", []string{`"y"`, "$x\\\nz"}},
+		{"a\\\n\"y\"", []string{"a\\\n", `"y"`}},
+		{"${x}\\\n\"y\"", []string{`${x}`, `"y"`}},
+		{"$1\\\n\"y\"", []string{`$1`, `"y"`}},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			src := "echo " + tc.in
+			f, err := p.Parse(strings.NewReader(src), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			w := f.Stmts[0].Cmd.(*CallExpr).Args[1]
+			var got []string
+			for _, part := range w.Parts {
+				got = append(got, string(NodeBytes([]byte(src), part)))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want parts %q in %q, got %q", tc.want, tc.in, got)
+			}
+			checkContiguous(t, src, w.Parts)
+			if got := string(NodeBytes([]byte(src), w)); got != tc.in {
+				t.Fatalf("want word span %q, got %q", tc.in, got)
+			}
+		})
+	}
+}
+
+func TestAssignPartSpans(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in          string
+		name, value string
+		valuePos    string
+	}{
+		{"x=1", "x", "1", "1:3"},
+		{"x\\\ny=1", "x\\\ny", "1", "2:3"},
+		{"x\\\r\ny=1", "x\\\r\ny", "1", "2:3"},
+		{"x\\\ny+=1", "x\\\ny", "1", "2:4"},
+		{"x=1\\\n2", "x", "1\\\n2", "1:3"},
+		{"a\\\nb\\\nc=d e", "a\\\nb\\\nc", "d", "3:3"},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			as := f.Stmts[0].Cmd.(*CallExpr).Assigns[0]
+			src := []byte(tc.in)
+			if got := string(NodeBytes(src, as.Name)); got != tc.name {
+				t.Fatalf("want name %q in %q, got %q", tc.name, tc.in, got)
+			}
+			if got := string(NodeBytes(src, as.Value)); got != tc.value {
+				t.Fatalf("want value %q in %q, got %q", tc.value, tc.in, got)
+			}
+			if got := as.Value.Pos().String(); got != tc.valuePos {
+				t.Fatalf("want value at %s in %q, got %s", tc.valuePos, tc.in, got)
+			}
+		})
+	}
+}
+
+func TestNodeBytes(t *testing.T) {
+	t.Parallel()
+	src := []byte("echo \"héllo\" ${world:-ñ}\nif ☃; then\n\tfoo 'ü'\nfi")
+	f, err := NewParser().Parse(bytes.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := f.Stmts[0].Cmd.(*CallExpr).Args
+	ifc := f.Stmts[1].Cmd.(*IfClause)
+	tests := []struct {
+		node Node
+		want string
+	}{
+		{args[1], `"héllo"`},
+		{args[2], `${world:-ñ}`},
+		{f.Stmts[0], "echo \"héllo\" ${world:-ñ}"},
+		{ifc.Cond[0], "☃;"},
+		{ifc.Then[0], "foo 'ü'"},
+		{ifc, "if ☃; then\n\tfoo 'ü'\nfi"},
+		{f, string(src)},
+	}
+	for i, tc := range tests {
+		got := string(NodeBytes(src, tc.node))
+		if got != tc.want {
+			t.Errorf("%03d: want %q, got %q", i, tc.want, got)
+		}
+	}
+	if got := NodeBytes(src, &Lit{}); got != nil {
+		t.Errorf("want nil for a node without positions, got %q", got)
+	}
+	if got := NodeBytes(src[:4], f); got != nil {
+		t.Errorf("want nil for a node past the end of src, got %q", got)
+	}
+}
+
+func TestIsEnvPrefix(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []bool
+	}{
+		{"FOO=1 cmd", []bool{true}},
+		{"FOO=1 BAR=2 cmd arg", []bool{true}},
+		{"FOO=1", []bool{false}},
+		{"FOO=1 BAR=2", []bool{false}},
+		{"FOO=1 >file", []bool{false}},
+		{"FOO=1 ; cmd", []bool{false, false}},
+		{"FOO=1\ncmd", []bool{false, false}},
+		{"cmd", []bool{false}},
+		{"{ FOO=1 cmd; }", []bool{false}},
+		{"FOO=1 cmd | BAR=2", []bool{false}},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []bool
+			for _, s := range f.Stmts {
+				got = append(got, IsEnvPrefix(s))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("IsEnvPrefix in %q: want %v, got %v",
+					tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIsNoop(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []bool
+	}{
+		{":", []bool{true}},
+		{": ignored args", []bool{true}},
+		{": 'single' \"double\" {a,b}", []bool{true}},
+		{"true", []bool{true}},
+		{"true; :", []bool{true, true}},
+		{"false", []bool{false}},
+		{"echo", []bool{false}},
+		{": ${foo:=bar}", []bool{false}},
+		{": \"$(rm foo)\"", []bool{false}},
+		{": $((i++))", []bool{false}},
+		{": <(rm foo)", []bool{false}},
+		{": >file", []bool{false}},
+		{"foo=bar :", []bool{false}},
+		{"! true", []bool{false}},
+		{"true &", []bool{false}},
+		{"\"true\"", []bool{false}},
+		{"{ :; }", []bool{false}},
+		{"true && :", []bool{false}},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []bool
+			for _, s := range f.Stmts {
+				got = append(got, IsNoop(s))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("IsNoop in %q: want %v, got %v",
+					tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRedirectDelimQuoted(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		op   RedirOperator
+		want bool
+	}{
+		{"cat <<X\n$a\nX", Hdoc, false},
+		{"cat <<-X\n\t$a\n\tX", DashHdoc, false},
+		{"cat <<'X'\n$a\nX", Hdoc, true},
+		{"cat <<-'X'\n\t$a\n\tX", DashHdoc, true},
+		{"cat <<\"X\"\n$a\nX", Hdoc, true},
+		{"cat <<\\X\n$a\nX", Hdoc, true},
+		{"cat <<X'Y'\n$a\nXY", Hdoc, true},
+		{"cat <<$'X'\n$a\nX", Hdoc, true},
+		{"cat <<<'X'", WordHdoc, false},
+		{"cat <'X'", RdrIn, false},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := f.Stmts[0].Redirs[0]
+			if r.Op != tc.op {
+				t.Fatalf("%q: want op %v, got %v", tc.in, tc.op, r.Op)
+			}
+			if got := r.DelimQuoted(); got != tc.want {
+				t.Fatalf("DelimQuoted in %q: want %v, got %v",
+					tc.in, tc.want, got)
+			}
+			// Quoted heredoc bodies are never expanded.
+			if tc.want && len(r.Hdoc.Parts) != 1 {
+				t.Fatalf("%q: want a single literal body, got %#v",
+					tc.in, r.Hdoc.Parts)
+			}
+		})
+	}
+}
+
+func TestRedirectKind(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in     string
+		fd     int
+		fdOk   bool
+		append bool
+		input  bool
+		output bool
+		dup    bool
+	}{
+		{"2>f", 2, true, false, false, true, false},
+		{">>f", 1, true, true, false, true, false},
+		{"<f", 0, true, false, true, false, false},
+		{">&1", 1, true, false, false, true, true},
+		{"<&-", 0, true, false, true, false, true},
+		{"3<&-", 3, true, false, true, false, true},
+		{"<>f", 0, true, false, true, true, false},
+		{">|f", 1, true, false, false, true, false},
+		{"&>>f", 1, true, true, false, true, false},
+		{"<<<x", 0, true, false, true, false, false},
+		{"<<EOF\nx\nEOF", 0, true, false, true, false, false},
+		{"{fd}>f", 0, false, false, false, true, false},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader("foo "+tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := f.Stmts[0].Redirs[0]
+			if fd, ok := r.Fd(); fd != tc.fd || ok != tc.fdOk {
+				t.Errorf("want Fd %d, %v in %q, got %d, %v", tc.fd, tc.fdOk, tc.in, fd, ok)
+			}
+			if got := r.IsAppend(); got != tc.append {
+				t.Errorf("want IsAppend %v in %q, got %v", tc.append, tc.in, got)
+			}
+			if got := r.IsInput(); got != tc.input {
+				t.Errorf("want IsInput %v in %q, got %v", tc.input, tc.in, got)
+			}
+			if got := r.IsOutput(); got != tc.output {
+				t.Errorf("want IsOutput %v in %q, got %v", tc.output, tc.in, got)
+			}
+			if got := r.IsDup(); got != tc.dup {
+				t.Errorf("want IsDup %v in %q, got %v", tc.dup, tc.in, got)
+			}
+		})
+	}
+}
+
+func TestRedirectIsClose(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"exec 3>&-", true},
+		{"exec 3<&-", true},
+		{"exec {fd}<&-", true},
+		{"exec >&-", true},
+		{"exec >&2", false},
+		{"exec 2>&1", false},
+		{"exec 3<&0", false},
+		{"exec >-", false},
+		{"exec >&-x", false},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Stmts[0].Redirs[0].IsClose(); got != tc.want {
+				t.Fatalf("want IsClose %v in %q, got %v", tc.want, tc.in, got)
+			}
+		})
+	}
+}
+
+func TestLitUnescaped(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"foo", "foo"},
+		{`a\ b`, "a b"},
+		{`a\nb`, "anb"},
+		{`a\\b`, `a\b`},
+		{`\$foo\*`, "$foo*"},
+		{"foo\\\nbar", "foobar"},
+		{`foo\`, `foo\`},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			var lit *Lit
+			err := p.Words(strings.NewReader(tc.in), func(w *Word) bool {
+				lit = w.Parts[0].(*Lit)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lit.Unescaped(); got != tc.want {
+				t.Fatalf("Unescaped(%q): want %q, got %q",
+					lit.Value, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParamExpAllElements(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"${a[@]}", "@"},
+		{"${a[*]}", "*"},
+		{"${#a[@]}", "@"},
+		{"${a[@]:1}", "@"},
+		{"${a[0]}", ""},
+		{"${a[i+1]}", ""},
+		{`${a["@"]}`, ""},
+		{"${a}", ""},
+		{"$@", ""},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			var pe *ParamExp
+			err := p.Words(strings.NewReader(tc.in), func(w *Word) bool {
+				pe = w.Parts[0].(*ParamExp)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pe.AllElements(); got != tc.want {
+				t.Fatalf("AllElements(%q): want %q, got %q",
+					tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWordKind(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in                              string
+		literal, quotedExp, unquotedExp bool
+	}{
+		{"foo", true, false, false},
+		{"'a $b'", true, false, false},
+		{`"a b"`, true, false, false},
+		{`$'\n'`, true, false, false},
+		{"*.go", true, false, false},
+		{"$args", false, false, true},
+		{"${a[@]}", false, false, true},
+		{"$(foo)", false, false, true},
+		{"$((1 + 2))", false, false, true},
+		{"<(foo)", false, false, true},
+		{"a>(foo)", false, false, true},
+		{"a${b:-c}d", false, false, true},
+		{`"$@"`, false, true, false},
+		{`"a $(b) c"`, false, true, false},
+		{`a"$b"c`, false, true, false},
+		{`a"$b"$c`, false, true, true},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			var words []*Word
+			err := p.Words(strings.NewReader(tc.in), func(w *Word) bool {
+				words = append(words, w)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			literal, quotedExp, unquotedExp := WordKind(words[0])
+			if literal != tc.literal || quotedExp != tc.quotedExp ||
+				unquotedExp != tc.unquotedExp {
+				t.Fatalf("WordKind(%q): want %v, %v, %v; got %v, %v, %v",
+					tc.in, tc.literal, tc.quotedExp, tc.unquotedExp,
+					literal, quotedExp, unquotedExp)
+			}
+		})
+	}
+}
+
+func TestDeclClauseHasOpt(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		has, not string
+	}{
+		{"declare -rx FOO=1", "rx", "ia"},
+		{"declare -i n", "i", "rx"},
+		{"declare -a -i n", "ai", "x"},
+		{"local +x -i n", "i", "x"},
+		{"declare -$flags n", "", "f"},
+		{"export -- -x", "", "x-"},
+		{"export -f myfunc", "f", "nx"},
+		{"export -n -p foo", "np", "f"},
+		{"declare", "", "i"},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			decl := f.Stmts[0].Cmd.(*DeclClause)
+			for _, opt := range []byte(tc.has) {
+				if !decl.HasOpt(opt) {
+					t.Errorf("%q should have option %q", tc.in, opt)
+				}
+			}
+			for _, opt := range []byte(tc.not) {
+				if decl.HasOpt(opt) {
+					t.Errorf("%q should not have option %q", tc.in, opt)
+				}
+			}
+		})
+	}
+}
+
+func TestDeclClauseIsNameref(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"declare -n r=v", true},
+		{"local -n r=v", true},
+		{"typeset -gn r", true},
+		{"nameref r=v", true},
+		{"declare r=v", false},
+		{"declare +n r", false},
+		{"declare -- -n", false},
+		{"export -n r", false},
+		{"readonly r=v", false},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			decl := f.Stmts[0].Cmd.(*DeclClause)
+			if got := decl.IsNameref(); got != tc.want {
+				t.Fatalf("want IsNameref %v in %q, got %v", tc.want, tc.in, got)
+			}
+		})
+	}
+}
@@ -5,10 +5,15 @@ package syntax
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -23,16 +28,47 @@ func KeepComments(enabled bool) ParserOption {
 	return func(p *Parser) { p.keepComments = enabled }
 }
 
+// KeepBlankLines makes the parser record how many blank lines directly
+// precede each statement, in the BlankLines field of Stmt. Lines with only
+// whitespace count as blank, while lines with comments do not. This can be
+// useful for tools that want to preserve the spacing between the sections of a
+// script.
+//
+// The end of the input is recorded as well, in the NoTrailingNewline and
+// TrailingBlankLines fields of File.
+func KeepBlankLines(enabled bool) ParserOption {
+	return func(p *Parser) { p.keepBlankLines = enabled }
+}
+
+// Continuations makes the parser record where escaped newlines split unquoted
+// literals, as byte offsets within Lit.Value added to m for each literal that
+// had any. For example, "foo\" followed by a newline and "bar" is parsed as the
+// literal "foobar" with a continuation at offset 3. This can be useful for tools
+// that want to preserve long words split across lines. The map is not cleared
+// between calls to Parse; a nil map disables the recording.
+func Continuations(m map[*Lit][]int) ParserOption {
+	return func(p *Parser) { p.conts = m }
+}
+
 type LangVariant int
 
 const (
 	LangBash LangVariant = iota
+
+	// LangPOSIX is the POSIX Shell Command Language. Features from other
+	// variants, such as arrays, [[ tests, or the function keyword, are
+	// rejected with a LangError.
+	//
+	// Note that "((" always opens nested subshells, and that builtins
+	// like local are parsed as regular commands, as many POSIX shells
+	// such as dash implement them.
 	LangPOSIX
+
 	LangMirBSDKorn
 )
 
 // Variant changes the shell language variant that the parser will
-// accept.
+// accept. Parsing with an unknown variant results in an error.
 func Variant(l LangVariant) ParserOption {
 	return func(p *Parser) { p.lang = l }
 }
@@ -72,6 +108,134 @@ func StopAt(word string) ParserOption {
 	return func(p *Parser) { p.stopAt = []byte(word) }
 }
 
+// CheckArithmNumbers makes the parser report an error for numeric
+// literals in arithmetic expressions which aren't valid in their base,
+// such as "08", "0xZ", or "2#102". Bases from 2 to 64 are supported with
+// the "base#digits" notation. By default, such literals are left as plain
+// words, as they are only rejected by shells when evaluated.
+func CheckArithmNumbers(enabled bool) ParserOption {
+	return func(p *Parser) { p.checkNumbers = enabled }
+}
+
+// Warnings makes the parser report non-fatal diagnostics to fn as it finds
+// them, which can be useful for linters. Warnings never stop the parser, and
+// fn is called in the order in which they are found.
+//
+// One warning is for an unquoted "$" which isn't followed by a parameter or
+// expansion, like in "echo $ foo". Shells keep it as a literal dollar sign, but
+// it's often a typo; "\$" or '$' are clearer. Other warnings are opt-in, such as
+// WarnBackquotes.
+func Warnings(fn func(Warning)) ParserOption {
+	return func(p *Parser) { p.warn = fn }
+}
+
+// WarnBackquotes makes the parser report a warning for each command
+// substitution using backquotes, like `cmd`, as "$(cmd)" nests and quotes in
+// a simpler way. The substitutions are still parsed as usual. It has no effect
+// unless Warnings is used too.
+func WarnBackquotes(enabled bool) ParserOption {
+	return func(p *Parser) { p.warnBquotes = enabled }
+}
+
+// TestCmds makes the parser produce a *TestCmd rather than a *CallExpr for
+// simple commands which run the "[" or "test" builtins, such as "[ -f foo ]".
+// This can be useful for tools like linters, which can then inspect the
+// structure of the test expression much like they can with "[[ ]]".
+//
+// Malformed test commands, such as "[ -f foo" without the closing "]" or
+// "[ a b ]", result in a parse error. Commands with assignments or redirects
+// before the name, or whose name is quoted, are left as a *CallExpr.
+func TestCmds(enabled bool) ParserOption {
+	return func(p *Parser) { p.testCmds = enabled }
+}
+
+// AliasClauses makes the parser produce an *AliasClause rather than a
+// *CallExpr for simple commands which run the "alias" builtin, such as
+// "alias ll='ls -l'" or "alias". This can be useful for tools which expand
+// aliases, as the name and value of each definition are split apart.
+//
+// As with TestCmds, commands with assignments before the name, or whose name is
+// quoted, are left as a *CallExpr.
+func AliasClauses(enabled bool) ParserOption {
+	return func(p *Parser) { p.aliasClauses = enabled }
+}
+
+// ExtraKeywords makes the parser treat the given words as reserved words when
+// they start a simple command, which can be useful when parsing a language
+// that extends the shell with its own keywords.
+//
+// Such a command is still parsed as a CallExpr with the keyword as its first
+// argument, but with its Keyword field set. Since the words are reserved, they
+// cannot be used as function names, as in "foo() { bar; }". As with other
+// reserved words, quoting them, like in "'foo' bar", is enough to turn them
+// back into regular words.
+//
+// Parsing results in an error if any of the words are empty, contain
+// whitespace, or are already reserved words like "if" or "[[".
+func ExtraKeywords(words ...string) ParserOption {
+	return func(p *Parser) { p.extraKeywords = words }
+}
+
+// StartPos makes the parser report positions as if the input started at the
+// given byte offset, line, and column, rather than at offset 0, line 1, and
+// column 1. This is useful when parsing a snippet extracted from a larger
+// document, such as a command in a Dockerfile or a script in a YAML file, so
+// that node positions and errors are relative to the whole document.
+//
+// The column only applies to the first line of the input, as the following
+// lines start at column 1. A line or column of zero is treated as 1.
+func StartPos(offset, line, col uint) ParserOption {
+	if line == 0 {
+		line = 1
+	}
+	if col == 0 {
+		col = 1
+	}
+	return func(p *Parser) {
+		p.startPos = Pos{offs: uint32(offset), line: uint16(line), col: uint16(col)}
+	}
+}
+
+// defaultMaxDepth is the maximum nesting depth used if MaxDepth is not set.
+const defaultMaxDepth = 5000
+
+// MaxDepth sets the maximum nesting depth that the parser will accept,
+// such as in subshells within subshells, or parentheses within arithmetic
+// expressions. Going over the limit results in a "nesting too deep" error,
+// which protects the parser from running out of stack space on pathological
+// input. A depth of zero or less means the default of 5000.
+func MaxDepth(depth int) ParserOption {
+	return func(p *Parser) { p.maxDepth = depth }
+}
+
+// MaxSize sets the maximum number of bytes of input that the parser will read.
+// Going over the limit stops the parser and results in an "input too large"
+// error, which allows rejecting large untrusted scripts before spending much
+// time or memory on them. A size of zero or less means no limit, the default.
+func MaxSize(size int) ParserOption {
+	return func(p *Parser) { p.maxSize = size }
+}
+
+// ParseStats holds statistics about a single call to Parser.Parse. See the
+// Stats parser option.
+type ParseStats struct {
+	Nodes    int           // number of nodes in the syntax tree, including the File
+	MaxDepth int           // maximum nesting depth reached; see MaxDepth
+	Heredocs int           // number of here-documents
+	Elapsed  time.Duration // time spent parsing
+}
+
+// Stats makes Parse fill s with statistics about the source it just parsed.
+// This can be useful to profile parsing across many files. Counting the nodes
+// requires an extra walk over the syntax tree, so this has a cost, but only when
+// s is not nil. If parsing fails, only MaxDepth and Elapsed are set.
+func Stats(s *ParseStats) ParserOption {
+	return func(p *Parser) { p.stats = s }
+}
+
+// errTooLarge is a read error meaning that MaxSize was reached.
+var errTooLarge = errors.New("input too large")
+
 // NewParser allocates a new Parser and applies any number of options.
 func NewParser(options ...ParserOption) *Parser {
 	p := &Parser{}
@@ -85,13 +249,41 @@ func NewParser(options ...ParserOption) *Parser {
 // returns the parsed program if no issues were encountered. Otherwise,
 // an error is returned. Reads from r are buffered.
 //
+// Even when an error is returned, the program is never nil. Its Stmts
+// contain all of the top-level statements which were fully parsed before
+// the one where the error was found, so that tools can still work with the
+// valid start of a file.
+//
 // Parse can be called more than once, but not concurrently. That is, a
-// Parser can be reused once it is done working.
+// Parser can be reused once it is done working. Reusing a Parser avoids
+// allocating its internal buffers again, and no state from a previous
+// call is carried over, even if it failed.
+//
+// The returned syntax tree does not reference the bytes read from r, nor
+// r itself. All strings in it, such as Lit.Value, are copies, so it is
+// safe to parse many small pieces of a large buffer and keep the results
+// without keeping the entire buffer alive.
+//
+// A "#!" line at the very start of the input is recorded as File.Shebang,
+// instead of being a comment.
+//
+// A UTF-8 byte order mark at the very start of the input is skipped. It
+// still counts towards position offsets, but not columns, so that the
+// first line's content starts at column 1.
+//
+// Outside of quotes, carriage returns are treated as spaces, so that
+// Windows line endings work just like newlines, even after a backslash.
+// Within quotes and heredoc bodies, they are kept as part of the string.
 func (p *Parser) Parse(r io.Reader, name string) (*File, error) {
+	if p.stats != nil {
+		defer p.fillStats(time.Now())
+	}
 	p.reset()
 	p.f = &File{Name: name}
 	p.src = r
 	p.rune()
+	p.skipBOM()
+	p.shebang()
 	p.next()
 	p.f.Stmts, p.f.Last = p.stmtList()
 	if p.err == nil {
@@ -99,17 +291,115 @@ func (p *Parser) Parse(r io.Reader, name string) (*File, error) {
 		// trigger it
 		p.doHeredocs()
 	}
+	if p.keepComments && p.err == nil {
+		p.splitHeaderComments()
+	}
+	if p.keepBlankLines && p.err == nil {
+		p.f.NoTrailingNewline = !p.eofNewline
+		if p.eofNewline && p.newlines > 1 {
+			// the first newline ends the last line
+			p.f.TrailingBlankLines = p.newlines - 1
+		}
+	}
+	p.src = nil
 	return p.f, p.err
 }
 
+// ParseContext is like Parse, but it stops early if ctx is cancelled, in which
+// case ctx.Err() is returned. This is useful to bound the time spent parsing
+// untrusted input, along with MaxSize.
+//
+// The context is checked each time more input is read, so a cancelled parse
+// stops after at most a few kilobytes of further input. As with any other
+// error, the returned program has the statements parsed until then.
+func (p *Parser) ParseContext(ctx context.Context, r io.Reader, name string) (*File, error) {
+	p.ctx = ctx
+	defer func() { p.ctx = nil }()
+	return p.Parse(r, name)
+}
+
+// fillStats sets the fields of p.stats once Parse is done.
+func (p *Parser) fillStats(start time.Time) {
+	s := ParseStats{MaxDepth: p.maxDepthSeen}
+	if p.err == nil {
+		Walk(p.f, func(node Node) bool {
+			if node == nil {
+				return true
+			}
+			s.Nodes++
+			if r, ok := node.(*Redirect); ok && (r.Op == Hdoc || r.Op == DashHdoc) {
+				s.Heredocs++
+			}
+			return true
+		})
+	}
+	s.Elapsed = time.Since(start)
+	*p.stats = s
+}
+
+// ParseFiles parses many shell programs concurrently, using the map keys
+// as their names. It returns the parsed programs for all the sources which
+// parsed without issues, and the errors for all the ones which didn't.
+//
+// Parsing is spread across a number of workers, each of them using its
+// own Parser created with the given options. Since a Parser must not be
+// used concurrently, no Parser is shared between workers. If workers is
+// zero or less, runtime.GOMAXPROCS(0) is used.
+func ParseFiles(srcs map[string][]byte, workers int, options ...ParserOption) (map[string]*File, map[string]error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	type result struct {
+		name string
+		f    *File
+		err  error
+	}
+	names := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := NewParser(options...)
+			for name := range names {
+				f, err := p.Parse(bytes.NewReader(srcs[name]), name)
+				results <- result{name, f, err}
+			}
+		}()
+	}
+	go func() {
+		for name := range srcs {
+			names <- name
+		}
+		close(names)
+		wg.Wait()
+		close(results)
+	}()
+	files := make(map[string]*File, len(srcs))
+	errs := make(map[string]error)
+	for res := range results {
+		if res.err != nil {
+			errs[res.name] = res.err
+		} else {
+			files[res.name] = res.f
+		}
+	}
+	return files, errs
+}
+
 // Stmts reads and parses statements one at a time, calling a function
 // each time one is parsed. If the function returns false, parsing is
 // stopped and the function is not called again.
+//
+// Unlike Parse, there is no File to record a "#!" line at the very start of
+// the input, so it is parsed as a regular comment.
 func (p *Parser) Stmts(r io.Reader, fn func(*Stmt) bool) error {
 	p.reset()
 	p.f = &File{}
 	p.src = r
 	p.rune()
+	p.skipBOM()
 	p.next()
 	p.stmts(fn)
 	if p.err == nil {
@@ -271,6 +561,8 @@ type Parser struct {
 	r   rune   // next rune
 	w   uint16 // width of r
 
+	escNewlCR bool // whether the escNewl in r was "\\\r\n"
+
 	f *File
 
 	spaced bool // whether tok has whitespace on its left
@@ -287,14 +579,42 @@ type Parser struct {
 
 	quote   quoteState // current lexer state
 	eqlOffs int        // position of '=' in val (a literal)
+	eqlPos  Pos        // position of '=' in the source, if eqlOffs is set
+
+	valConts []int // escaped newline offsets in val; see Continuations
+
+	newlines   int  // newlines since the last token or comment; see KeepBlankLines
+	eofNewline bool // whether the input ended with a newline
+
+	keepComments   bool
+	keepBlankLines bool
+	lang           LangVariant
+	checkNumbers   bool
+	testCmds       bool
+	aliasClauses   bool
+	maxDepth       int
+	maxSize        int
 
-	keepComments bool
-	lang         LangVariant
+	conts map[*Lit][]int // see Continuations
+
+	stats *ParseStats // see Stats
+
+	ctx context.Context // see ParseContext
+
+	warn        func(Warning) // see Warnings
+	warnBquotes bool
 
 	stopAt []byte
 
+	startPos Pos // see StartPos
+
+	extraKeywords []string
+
 	forbidNested bool
 
+	depth        int // current nesting depth; see MaxDepth
+	maxDepthSeen int // see ParseStats
+
 	// list of pending heredoc bodies
 	buriedHdocs int
 	heredocs    []*Redirect
@@ -312,6 +632,10 @@ type Parser struct {
 
 	// lastBquoteEsc is how many times the last backquote token was escaped
 	lastBquoteEsc int
+	// bquoteEsc is lastBquoteEsc for a backquote after a run of
+	// backslashes, and bquoteBsl is how many of the run's remaining
+	// backslashes are still to be returned; see unescapeBquoteRun.
+	bquoteEsc, bquoteBsl int
 	// buriedBquotes is like openBquotes, but saved for when the parser
 	// comes out of single quotes
 	buriedBquotes int
@@ -328,6 +652,8 @@ type Parser struct {
 	stmtBatch   []Stmt
 	stListBatch []*Stmt
 	callBatch   []callAlloc
+	asBatch     []Assign
+	peBatch     []ParamExp
 
 	readBuf [bufSize]byte
 	litBuf  [bufSize]byte
@@ -352,21 +678,108 @@ const bufSize = 1 << 10
 func (p *Parser) reset() {
 	p.tok, p.val = illegalTok, ""
 	p.eqlOffs = 0
+	p.newlines = 1 // the start of the input acts like a newline
+	p.eofNewline = false
 	p.bs, p.bsp = nil, 0
 	p.offs = 0
-	p.npos = Pos{line: 1, col: 1}
+	p.npos = p.startPos
+	if !p.npos.IsValid() {
+		p.npos = Pos{line: 1, col: 1}
+	}
 	p.r, p.w = 0, 0
-	p.err, p.readErr = nil, nil
+	p.err, p.readErr = p.checkOptions(), nil
 	p.quote, p.forbidNested = noState, false
+	p.depth, p.maxDepthSeen = 0, 0
 	p.openStmts = 0
-	p.heredocs, p.buriedHdocs = p.heredocs[:0], 0
+	// Keep the backing arrays to reuse them, but don't let them keep
+	// nodes from a previous parse alive.
+	hdocs := p.heredocs[:cap(p.heredocs)]
+	for i := range hdocs {
+		hdocs[i] = nil
+	}
+	p.heredocs, p.buriedHdocs = hdocs[:0], 0
+	stops := p.hdocStops[:cap(p.hdocStops)]
+	for i := range stops {
+		stops[i] = nil
+	}
+	p.hdocStops = stops[:0]
 	p.parsingDoc = false
-	p.openBquotes, p.buriedBquotes = 0, 0
+	p.openBquotes, p.buriedBquotes, p.lastBquoteEsc = 0, 0, 0
+	p.bquoteEsc, p.bquoteBsl = 0, 0
+	p.rxOpenParens, p.rxFirstPart = 0, false
+	p.litBs = nil
 	p.accComs, p.curComs = nil, &p.accComs
 }
 
+// skipBOM skips a UTF-8 byte order mark, which some editors add at the
+// start of files. It must only be called after reading the first rune.
+func (p *Parser) skipBOM() {
+	if p.r == '\uFEFF' {
+		p.rune()
+		p.npos.col -= 3 // don't count the three bytes of the mark
+	}
+}
+
+// shebang reads a "#!" line at the very start of the input into File.Shebang.
+func (p *Parser) shebang() {
+	if p.r != '#' || p.offs+p.bsp-int(p.w) != 0 || !p.peekByte('!') {
+		return
+	}
+	r := p.r
+	p.newLit(r)
+	for r != '\n' && r != utf8.RuneSelf {
+		if r == escNewl {
+			// the kernel doesn't support line continuations
+			p.litBs = append(p.litBs, '\\')
+			break
+		}
+		r = p.rune()
+	}
+	p.f.Shebang = strings.TrimSuffix(p.endLit(), "\r")
+	p.newlines = 0
+}
+
+// splitHeaderComments moves the run of comments at the start of the file into
+// File.HeaderComments, if a blank line follows it. The comments are found at
+// the start of the first statement, or in File.Last if there are none.
+func (p *Parser) splitHeaderComments() {
+	coms := &p.f.Last
+	var next Pos
+	if len(p.f.Stmts) > 0 {
+		s := p.f.Stmts[0]
+		coms, next = &s.Comments, s.Pos()
+	}
+	cs := *coms
+	if len(cs) == 0 || (next.IsValid() && cs[0].Pos().After(next)) {
+		return
+	}
+	n := 1
+	for n < len(cs) && cs[n].Hash.Line() == cs[n-1].Hash.Line()+1 {
+		n++
+	}
+	if n < len(cs) && (!next.IsValid() || next.After(cs[n].Pos())) {
+		next = cs[n].Pos()
+	}
+	if !next.IsValid() || next.Line() <= cs[n-1].Hash.Line()+1 {
+		return // nothing follows, or no blank line
+	}
+	p.f.HeaderComments = cs[:n]
+	if *coms = cs[n:]; len(*coms) == 0 {
+		*coms = nil
+	}
+}
+
 func (p *Parser) getPos() Pos {
-	p.npos.offs = uint32(p.offs + p.bsp - int(p.w))
+	offs := p.offs + p.bsp - int(p.w)
+	if p.r == escNewl {
+		// p.w is 1, but the rune spans "\\\n" or "\\\r\n", and its
+		// position is that of the backslash.
+		offs--
+		if p.escNewlCR {
+			offs--
+		}
+	}
+	p.npos.offs = p.startPos.offs + uint32(offs)
 	return p.npos
 }
 
@@ -382,6 +795,16 @@ func (p *Parser) lit(pos Pos, val string) *Lit {
 	return l
 }
 
+// valLit is like lit for the current literal token, also recording its
+// escaped newlines; see Continuations.
+func (p *Parser) valLit() *Lit {
+	l := p.lit(p.pos, p.val)
+	if len(p.valConts) > 0 {
+		p.conts[l] = p.valConts
+	}
+	return l
+}
+
 func (p *Parser) word(parts []WordPart) *Word {
 	if len(p.wordBatch) == 0 {
 		p.wordBatch = make([]Word, 64)
@@ -438,6 +861,25 @@ func (p *Parser) call(w *Word) *CallExpr {
 	return ce
 }
 
+func (p *Parser) assign() *Assign {
+	if len(p.asBatch) == 0 {
+		p.asBatch = make([]Assign, 32)
+	}
+	as := &p.asBatch[0]
+	p.asBatch = p.asBatch[1:]
+	return as
+}
+
+func (p *Parser) paramExpAt(pos Pos) *ParamExp {
+	if len(p.peBatch) == 0 {
+		p.peBatch = make([]ParamExp, 32)
+	}
+	pe := &p.peBatch[0]
+	p.peBatch = p.peBatch[1:]
+	pe.Dollar = pos
+	return pe
+}
+
 //go:generate stringer -type=quoteState
 
 type quoteState uint32
@@ -630,19 +1072,40 @@ func (p *Parser) followWordTok(tok token, pos Pos) *Word {
 func (p *Parser) stmtEnd(n Node, start, end string) Pos {
 	pos, ok := p.gotRsrv(end)
 	if !ok {
-		p.posErr(n.Pos(), "%s statement must end with %q", start, end)
+		p.posErr(n.Pos(), "%s statement must end with %q, reached %s at %s",
+			start, end, p.tokDesc(), p.tokPos())
 	}
 	return pos
 }
 
 func (p *Parser) quoteErr(lpos Pos, quote token) {
-	p.posErr(lpos, "reached %s without closing quote %s",
-		p.tok.String(), quote)
+	p.posErr(lpos, "reached %s at %s without closing quote %s",
+		p.tokDesc(), p.tokPos(), quote)
 }
 
 func (p *Parser) matchingErr(lpos Pos, left, right interface{}) {
-	p.posErr(lpos, "reached %s without matching %s with %s",
-		p.tok.String(), left, right)
+	p.posErr(lpos, "reached %s at %s without matching %s with %s",
+		p.tokDesc(), p.tokPos(), left, right)
+}
+
+// tokPos returns the position of the current token, for errors reported at
+// an opening position which also say where the parser gave up, typically the
+// end of the input.
+func (p *Parser) tokPos() Pos {
+	if p.tok == _EOF {
+		// Lexing quotes may reach EOF without updating p.pos.
+		return p.getPos()
+	}
+	return p.pos
+}
+
+// tokDesc describes the current token for error messages.
+func (p *Parser) tokDesc() string {
+	switch p.tok {
+	case _Lit, _LitWord, _LitRedir:
+		return strconv.Quote(p.val)
+	}
+	return p.tok.String()
 }
 
 func (p *Parser) matched(lpos Pos, left, right token) Pos {
@@ -653,6 +1116,24 @@ func (p *Parser) matched(lpos Pos, left, right token) Pos {
 	return pos
 }
 
+// enterNested increments the nesting depth, erroring if it goes over the
+// maximum. Each call must be paired with a call to leaveNested.
+func (p *Parser) enterNested() {
+	p.depth++
+	if p.depth > p.maxDepthSeen {
+		p.maxDepthSeen = p.depth
+	}
+	max := p.maxDepth
+	if max <= 0 {
+		max = defaultMaxDepth
+	}
+	if p.depth > max {
+		p.curErr("nesting too deep")
+	}
+}
+
+func (p *Parser) leaveNested() { p.depth-- }
+
 func (p *Parser) errPass(err error) {
 	if p.err == nil {
 		p.err = err
@@ -673,6 +1154,9 @@ func IsIncomplete(err error) bool {
 
 // ParseError represents an error found when parsing a source file, from which
 // the parser cannot recover.
+//
+// The embedded Pos is where the error was found, and its Offset method gives
+// the byte offset into the parsed source, on top of its line and column.
 type ParseError struct {
 	Filename string
 	Pos
@@ -688,6 +1172,22 @@ func (e ParseError) Error() string {
 	return fmt.Sprintf("%s:%s: %s", e.Filename, e.Pos.String(), e.Text)
 }
 
+// Warning is a non-fatal diagnostic found when parsing a source file, such as
+// a piece of syntax which shells accept but which is often a mistake. See the
+// Warnings parser option.
+type Warning struct {
+	Filename string
+	Pos
+	Text string
+}
+
+func (w Warning) String() string {
+	if w.Filename == "" {
+		return fmt.Sprintf("%s: %s", w.Pos.String(), w.Text)
+	}
+	return fmt.Sprintf("%s:%s: %s", w.Filename, w.Pos.String(), w.Text)
+}
+
 // LangError is returned when the parser encounters code that is only valid in
 // other shell language variants. The error includes what feature is not present
 // in the current language variant, and what languages support it.
@@ -729,6 +1229,16 @@ func (p *Parser) posErr(pos Pos, format string, a ...interface{}) {
 	})
 }
 
+func (p *Parser) warnf(pos Pos, format string, a ...interface{}) {
+	if p.warn != nil {
+		p.warn(Warning{
+			Filename: p.f.Name,
+			Pos:      pos,
+			Text:     fmt.Sprintf(format, a...),
+		})
+	}
+}
+
 func (p *Parser) curErr(format string, a ...interface{}) {
 	p.posErr(p.pos, format, a...)
 }
@@ -829,6 +1339,10 @@ func (p *Parser) invalidStmtStart() {
 	case semicolon, and, or, andAnd, orOr:
 		p.curErr("%s can only immediately follow a statement", p.tok)
 	case rightParen:
+		if p.peekArithmEnd() {
+			p.curErr("%s can only be used to close an arithmetic expression", dblRightParen)
+			break
+		}
 		p.curErr("%s can only be used to close a subshell", p.tok)
 	default:
 		p.curErr("%s is not a valid start for a statement", p.tok)
@@ -845,7 +1359,7 @@ func (p *Parser) getWord() *Word {
 func (p *Parser) getLit() *Lit {
 	switch p.tok {
 	case _Lit, _LitWord, _LitRedir:
-		l := p.lit(p.pos, p.val)
+		l := p.valLit()
 		p.next()
 		return l
 	}
@@ -858,9 +1372,14 @@ func (p *Parser) wordParts() (wps []WordPart) {
 		if n == nil {
 			return
 		}
-		if wps == nil {
+		switch len(wps) {
+		case 0:
 			wps = p.wps(n)
-		} else {
+		case 1:
+			// Words with more than one part tend to have a few;
+			// skip growing the slice one part at a time.
+			wps = append(make([]WordPart, 0, 4), wps[0], n)
+		default:
 			wps = append(wps, n)
 		}
 		if p.spaced {
@@ -878,7 +1397,7 @@ func (p *Parser) ensureNoNested() {
 func (p *Parser) wordPart() WordPart {
 	switch p.tok {
 	case _Lit, _LitWord:
-		l := p.lit(p.pos, p.val)
+		l := p.valLit()
 		p.next()
 		return l
 	case dollBrace:
@@ -960,12 +1479,16 @@ func (p *Parser) wordPart() WordPart {
 			'0' <= r && r <= '9', r == '_', r == '\\':
 			p.advanceNameCont(r)
 		default:
+			if p.quote&allRegTokens != 0 && endsWord(r) {
+				p.warnf(p.pos, `unquoted "$" is a literal dollar sign`)
+			}
 			l := p.lit(p.pos, "$")
 			p.next()
 			return l
 		}
 		p.ensureNoNested()
-		pe := &ParamExp{Dollar: p.pos, Short: true}
+		pe := p.paramExpAt(p.pos)
+		pe.Short = true
 		p.pos = posAddCol(p.pos, 1)
 		pe.Param = p.getLit()
 		if pe.Param != nil && pe.Param.Value == "" {
@@ -1006,7 +1529,7 @@ func (p *Parser) wordPart() WordPart {
 				p.next()
 				return sq
 			case escNewl:
-				p.litBs = append(p.litBs, '\\', '\n')
+				p.litBs = append(p.litBs, p.escNewlSrc()...)
 			case utf8.RuneSelf:
 				p.tok = _EOF
 				p.quoteErr(sq.Pos(), sglQuote)
@@ -1025,6 +1548,9 @@ func (p *Parser) wordPart() WordPart {
 		}
 		p.ensureNoNested()
 		cs := &CmdSubst{Left: p.pos, Backquotes: true}
+		if p.warnBquotes {
+			p.warnf(cs.Left, "use $(...) instead of backquotes")
+		}
 		old := p.preNested(subCmdBckquo)
 		p.openBquotes++
 
@@ -1222,6 +1748,8 @@ func isArithName(left ArithmExpr) bool {
 }
 
 func (p *Parser) arithmExprBase(compact bool) ArithmExpr {
+	p.enterNested()
+	defer p.leaveNested()
 	p.got(_Newl)
 	var x ArithmExpr
 	switch p.tok {
@@ -1259,11 +1787,15 @@ func (p *Parser) arithmExprBase(compact bool) ArithmExpr {
 	case _LitWord:
 		l := p.getLit()
 		if p.tok != leftBrack {
+			if p.checkNumbers {
+				p.checkArithmNumber(l)
+			}
 			x = p.word(p.wps(l))
 			break
 		}
-		pe := &ParamExp{Dollar: l.ValuePos, Short: true, Param: l}
-		pe.Index = p.eitherIndex()
+		pe := p.paramExpAt(l.ValuePos)
+		pe.Short, pe.Param = true, l
+		pe.Index, _ = p.eitherIndex()
 		x = p.word(p.wps(pe))
 	case bckQuote:
 		if p.quote == arithmExprLet && p.openBquotes > 0 {
@@ -1296,6 +1828,52 @@ func (p *Parser) arithmExprBase(compact bool) ArithmExpr {
 	return x
 }
 
+// checkArithmNumber reports an error if l is a number which isn't valid
+// in its base, be it decimal, octal with a leading "0", or hexadecimal
+// with a leading "0x".
+func (p *Parser) checkArithmNumber(l *Lit) {
+	val := l.Value
+	if val == "" || val[0] < '0' || val[0] > '9' {
+		return // not a number
+	}
+	if i := strings.IndexByte(val, '#'); i >= 0 {
+		base, err := strconv.Atoi(val[:i])
+		if err != nil || base < 2 || base > 64 {
+			p.posErr(l.Pos(), "invalid arithmetic base: %s", val)
+			return
+		}
+		if !validBaseDigits(val[i+1:], base) {
+			p.posErr(l.Pos(), "invalid base %d number: %s", base, val)
+		}
+		return
+	}
+	base, digits, kind := 10, val, "number"
+	switch {
+	case strings.HasPrefix(val, "0x"), strings.HasPrefix(val, "0X"):
+		base, digits, kind = 16, val[2:], "hexadecimal number"
+	case len(val) > 1 && val[0] == '0':
+		base, digits, kind = 8, val[1:], "octal number"
+	}
+	_, err := strconv.ParseUint(digits, base, 64)
+	if err != nil && err.(*strconv.NumError).Err == strconv.ErrSyntax {
+		p.posErr(l.Pos(), "invalid %s: %s", kind, val)
+	}
+}
+
+// validBaseDigits reports whether digits is a valid number in an arithmetic
+// base between 2 and 64, as in "base#digits".
+func validBaseDigits(digits string, base int) bool {
+	if digits == "" {
+		return false
+	}
+	for _, r := range digits {
+		if _, ok := baseDigit(r, base); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func singleRuneParam(r rune) bool {
 	switch r {
 	case '@', '*', '#', '$', '?', '!', '-',
@@ -1305,8 +1883,19 @@ func singleRuneParam(r rune) bool {
 	return false
 }
 
+// endsWord reports whether r ends an unquoted word, if it follows it.
+func endsWord(r rune) bool {
+	switch r {
+	case utf8.RuneSelf, ' ', '\t', '\r', '\n', ';', '|', '&', '<', '>', ')':
+		return true
+	}
+	return false
+}
+
 func (p *Parser) paramExp() *ParamExp {
-	pe := &ParamExp{Dollar: p.pos}
+	p.enterNested()
+	defer p.leaveNested()
+	pe := p.paramExpAt(p.pos)
 	old := p.quote
 	p.quote = paramExpName
 	if p.r == '#' {
@@ -1377,7 +1966,7 @@ func (p *Parser) paramExp() *ParamExp {
 		if !ValidName(pe.Param.Value) {
 			p.curErr("cannot index a special parameter name")
 		}
-		pe.Index = p.eitherIndex()
+		pe.Index, _ = p.eitherIndex()
 	}
 	if p.tok == rightBrace {
 		pe.Rbrace = p.pos
@@ -1467,7 +2056,9 @@ func (p *Parser) paramExpExp() *Expansion {
 	return &Expansion{Op: op, Word: p.getWord()}
 }
 
-func (p *Parser) eitherIndex() ArithmExpr {
+// eitherIndex parses an index like "[i]", returning the expression and the
+// position of the closing bracket.
+func (p *Parser) eitherIndex() (ArithmExpr, Pos) {
 	old := p.quote
 	lpos := p.pos
 	p.quote = arithmExprBrack
@@ -1477,8 +2068,8 @@ func (p *Parser) eitherIndex() ArithmExpr {
 	}
 	expr := p.followArithm(leftBrack, lpos)
 	p.quote = old
-	p.matched(lpos, leftBrack, rightBrack)
-	return expr
+	rpos := p.matched(lpos, leftBrack, rightBrack)
+	return expr, rpos
 }
 
 func (p *Parser) peekArithmEnd() bool {
@@ -1509,6 +2100,19 @@ func (p *Parser) backquoteEnd() bool {
 	return p.quote == subCmdBckquo && p.lastBquoteEsc < p.openBquotes
 }
 
+// IsKeyword returns true if the given word is one of the shell's reserved
+// words, such as "if" or "[[". Builtins which are parsed specially, like
+// "declare" or "let", are not reserved words.
+func IsKeyword(word string) bool {
+	switch word {
+	case "!", "{", "}", "[[", "]]", "case", "coproc", "do", "done", "elif",
+		"else", "esac", "fi", "for", "function", "if", "in", "select",
+		"then", "time", "until", "while":
+		return true
+	}
+	return false
+}
+
 // ValidName returns whether val is a valid name as per the POSIX spec.
 func ValidName(val string) bool {
 	if val == "" {
@@ -1554,7 +2158,7 @@ func (p *Parser) hasValidIdent() bool {
 }
 
 func (p *Parser) getAssign(needEqual bool) *Assign {
-	as := &Assign{}
+	as := p.assign()
 	if p.eqlOffs > 0 { // foo=bar
 		nameEnd := p.eqlOffs
 		if p.lang != LangPOSIX && p.val[p.eqlOffs-1] == '+' {
@@ -1563,11 +2167,23 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 			nameEnd--
 		}
 		as.Name = p.lit(p.pos, p.val[:nameEnd])
-		// since we're not using the entire p.val
-		as.Name.ValueEnd = posAddCol(as.Name.ValuePos, nameEnd)
-		left := p.lit(posAddCol(p.pos, 1), p.val[p.eqlOffs+1:])
+		// since we're not using the entire p.val; the positions are
+		// relative to the '=', as escaped newlines may come before it
+		as.Name.ValueEnd = p.eqlPos
+		if as.Append && p.eqlPos.Col() > 1 {
+			as.Name.ValueEnd = posAddCol(p.eqlPos, -1)
+		}
+		left := p.lit(posAddCol(p.eqlPos, 1), p.val[p.eqlOffs+1:])
 		if left.Value != "" {
-			left.ValuePos = posAddCol(left.ValuePos, p.eqlOffs)
+			var conts []int
+			for _, offs := range p.valConts {
+				if offs > p.eqlOffs {
+					conts = append(conts, offs-p.eqlOffs-1)
+				}
+			}
+			if len(conts) > 0 {
+				p.conts[left] = conts
+			}
 			as.Value = p.word(p.wps(left))
 		}
 		p.next()
@@ -1576,7 +2192,8 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 		// hasValidIdent already checks p.r is '['
 		p.rune()
 		p.pos = posAddCol(p.pos, 1)
-		as.Index = p.eitherIndex()
+		as.Lbrack = as.Name.End()
+		as.Index, as.Rbrack = p.eitherIndex()
 		if p.spaced || stopToken(p.tok) {
 			if needEqual {
 				p.followErr(as.Pos(), "a[b]", "=")
@@ -1626,9 +2243,9 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 			ae := &ArrayElem{}
 			ae.Comments, p.accComs = p.accComs, nil
 			if p.tok == leftBrack {
-				left := p.pos
-				ae.Index = p.eitherIndex()
-				p.follow(left, `"[x]"`, assgn)
+				ae.Lbrack = p.pos
+				ae.Index, ae.Rbrack = p.eitherIndex()
+				p.follow(ae.Lbrack, `"[x]"`, assgn)
 			}
 			if ae.Value = p.getWord(); ae.Value == nil {
 				switch p.tok {
@@ -1717,17 +2334,11 @@ func (p *Parser) doRedirect(s *Stmt) {
 }
 
 func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
-	pos, ok := p.gotRsrv("!")
-	s := p.stmt(pos)
-	if ok {
-		s.Negated = true
-		if stopToken(p.tok) {
-			p.posErr(s.Pos(), `"!" cannot form a statement alone`)
-		}
-		if _, ok := p.gotRsrv("!"); ok {
-			p.posErr(s.Pos(), `cannot negate a command multiple times`)
-		}
-	}
+	p.enterNested()
+	defer p.leaveNested()
+	s := p.stmt(p.pos)
+	p.gotBlankLines(s)
+	p.gotNegation(s)
 	if s = p.gotStmtPipe(s, false); s == nil || p.err != nil {
 		return nil
 	}
@@ -1753,6 +2364,7 @@ func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 		s = p.stmt(s.Position)
 		s.Cmd = b
 		s.Comments, b.X.Comments = b.X.Comments, nil
+		s.BlankLines, b.X.BlankLines = b.X.BlankLines, 0
 	}
 	if readEnd {
 		switch p.tok {
@@ -1779,10 +2391,81 @@ func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 	return s
 }
 
+// gotBlankLines records the blank lines before s, if KeepBlankLines is set.
+func (p *Parser) gotBlankLines(s *Stmt) {
+	if p.keepBlankLines && p.newlines > 1 {
+		// the first newline ends the previous line
+		s.BlankLines = p.newlines - 1
+	}
+}
+
+// gotNegation marks s as negated if the current token is "!". Like in Bash,
+// each extra "!" toggles the negation, so "! ! foo" is not negated. The number
+// of "!" is kept in Negations if there are more than one.
+func (p *Parser) gotNegation(s *Stmt) {
+	if _, ok := p.gotRsrv("!"); !ok {
+		return
+	}
+	s.Negated = true
+	n := 1
+	for {
+		if stopToken(p.tok) {
+			p.posErr(s.Pos(), `"!" cannot form a statement alone`)
+		}
+		if _, ok := p.gotRsrv("!"); !ok {
+			break
+		}
+		if p.lang == LangPOSIX {
+			p.posErr(s.Pos(), `cannot negate a command multiple times`)
+		}
+		s.Negated = !s.Negated
+		n++
+	}
+	if n > 1 {
+		s.Negations = n
+	}
+}
+
+// checkOptions returns an error if the parser options contradict each other,
+// such as extra keywords which are already reserved words.
+func (p *Parser) checkOptions() error {
+	switch p.lang {
+	case LangBash, LangPOSIX, LangMirBSDKorn:
+	default:
+		return fmt.Errorf("invalid parser options: %s: %d", p.lang, int(p.lang))
+	}
+	for _, word := range p.extraKeywords {
+		if word == "" || strings.ContainsAny(word, " \t\r\n") {
+			return fmt.Errorf("invalid parser options: extra keyword %q must be a single word", word)
+		}
+		if IsKeyword(word) {
+			return fmt.Errorf("invalid parser options: extra keyword %q is already a reserved word", word)
+		}
+	}
+	return nil
+}
+
+func (p *Parser) isExtraKeyword(val string) bool {
+	for _, word := range p.extraKeywords {
+		if val == word {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 	s.Comments, p.accComs = p.accComs, nil
 	switch p.tok {
 	case _LitWord:
+		if p.isExtraKeyword(p.val) && !p.hasValidIdent() {
+			// extra keywords take precedence over builtins like "let"
+			name := p.lit(p.pos, p.val)
+			p.next()
+			p.callExpr(s, p.word(p.wps(name)), false)
+			s.Cmd.(*CallExpr).Keyword = true
+			break
+		}
 		switch p.val {
 		case "{":
 			p.block(s)
@@ -1796,9 +2479,7 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 			p.caseClause(s)
 		case "}":
 			p.curErr(`%q can only be used to close a block`, p.val)
-		case "then":
-			p.curErr(`%q can only be used in an if`, p.val)
-		case "elif":
+		case "then", "elif", "else":
 			p.curErr(`%q can only be used in an if`, p.val)
 		case "fi":
 			p.curErr(`%q can only be used to end an if`, p.val)
@@ -1814,9 +2495,13 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 				break
 			}
 		case "[[":
-			if p.lang != LangPOSIX {
-				p.testClause(s)
+			if p.lang == LangPOSIX {
+				// POSIX leaves the results of using reserved words
+				// like [[ and function unspecified.
+				p.langErr(p.pos, "[[ tests", LangBash, LangMirBSDKorn)
+				break
 			}
+			p.testClause(s)
 		case "]]":
 			if p.lang != LangPOSIX {
 				p.curErr(`%q can only be used to close a test`,
@@ -1827,9 +2512,11 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 				p.letClause(s)
 			}
 		case "function":
-			if p.lang != LangPOSIX {
-				p.bashFuncDecl(s)
+			if p.lang == LangPOSIX {
+				p.langErr(p.pos, `the "function" keyword`, LangBash, LangMirBSDKorn)
+				break
 			}
+			p.bashFuncDecl(s)
 		case "declare":
 			if p.lang == LangBash {
 				p.declClause(s)
@@ -1867,6 +2554,12 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 			p.funcDecl(s, name, name.ValuePos)
 		} else {
 			p.callExpr(s, p.word(p.wps(name)), false)
+			if p.testCmds {
+				p.testCmd(s)
+			}
+			if p.aliasClauses {
+				p.aliasClause(s)
+			}
 		}
 	case rdrOut, appOut, rdrIn, dplIn, dplOut, clbOut, rdrInOut,
 		hdoc, dashHdoc, wordHdoc, rdrAll, appAll, _LitRedir:
@@ -1916,16 +2609,19 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 		b := &BinaryCmd{OpPos: p.pos, Op: BinCmdOperator(p.tok), X: s}
 		p.next()
 		p.got(_Newl)
-		if b.Y = p.gotStmtPipe(p.stmt(p.pos), true); b.Y == nil || p.err != nil {
+		b.Y = p.stmt(p.pos)
+		p.gotBlankLines(b.Y)
+		if b.Y = p.gotStmtPipe(b.Y, true); b.Y == nil || p.err != nil {
 			p.followErr(b.OpPos, b.Op.String(), "a statement")
 			break
 		}
 		s = p.stmt(s.Position)
 		s.Cmd = b
 		s.Comments, b.X.Comments = b.X.Comments, nil
+		s.BlankLines, b.X.BlankLines = b.X.BlankLines, 0
 		// in "! x | y", the bang applies to the entire pipeline
-		s.Negated = b.X.Negated
-		b.X.Negated = false
+		s.Negated, s.Negations = b.X.Negated, b.X.Negations
+		b.X.Negated, b.X.Negations = false, 0
 	}
 	return s
 }
@@ -1962,11 +2658,32 @@ func (p *Parser) block(s *Stmt) {
 	pos, ok := p.gotRsrv("}")
 	b.Rbrace = pos
 	if !ok {
+		if rpos := argRbrace(b.Stmts); rpos.IsValid() {
+			p.posErr(b.Lbrace, "reached %s at %s without matching { with }; "+
+				"the } at %s must follow ; or a newline",
+				p.tokDesc(), p.tokPos(), rpos)
+		}
 		p.matchingErr(b.Lbrace, "{", "}")
 	}
 	s.Cmd = b
 }
 
+// argRbrace returns the position of the last "}" which ends a statement in
+// stmts as an argument, like in "{ foo }". Such a "}" is a common mistake,
+// as it does not close a block.
+func argRbrace(stmts []*Stmt) Pos {
+	for i := len(stmts) - 1; i >= 0; i-- {
+		ce, ok := stmts[i].Cmd.(*CallExpr)
+		if !ok || len(ce.Args) < 2 {
+			continue
+		}
+		if w := ce.Args[len(ce.Args)-1]; w.Lit() == "}" {
+			return w.Pos()
+		}
+	}
+	return Pos{}
+}
+
 func (p *Parser) ifClause(s *Stmt) {
 	rootIf := &IfClause{Position: p.pos}
 	p.next()
@@ -2137,7 +2854,10 @@ func (p *Parser) caseItems(stop string) (items []*CaseItem) {
 	for p.tok != _EOF && !(p.tok == _LitWord && p.val == stop) {
 		ci := &CaseItem{}
 		ci.Comments, p.accComs = p.accComs, nil
-		p.got(leftParen)
+		if p.tok == leftParen {
+			ci.Lparen = p.pos
+			p.next()
+		}
 		for p.tok != _EOF {
 			if w := p.getWord(); w == nil {
 				p.curErr("case patterns must consist of words")
@@ -2151,6 +2871,7 @@ func (p *Parser) caseItems(stop string) (items []*CaseItem) {
 				p.curErr("case patterns must be separated with |")
 			}
 		}
+		ci.Rparen = p.pos
 		old := p.preNested(switchCase)
 		p.next()
 		ci.Stmts, ci.Last = p.stmtList(stop)
@@ -2199,34 +2920,76 @@ func (p *Parser) testClause(s *Stmt) {
 	s.Cmd = tc
 }
 
-func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
-	p.got(_Newl)
+// testExpr parses a test expression. As in Bash, && has a higher precedence
+// than ||, and both are left-associative, so "a || b && c || d" is parsed as
+// "(a || (b && c)) || d". If andOnly is true, it stops before any ||.
+func (p *Parser) testExpr(ftok token, fpos Pos, andOnly bool) TestExpr {
 	var left TestExpr
-	if pastAndOr {
-		left = p.testExprBase(ftok, fpos)
+	if andOnly {
+		left = p.testExprComp(ftok, fpos)
 	} else {
 		left = p.testExpr(ftok, fpos, true)
 	}
-	if left == nil {
-		return left
+	for left != nil {
+		p.got(_Newl)
+		if p.tok != andAnd && (andOnly || p.tok != orOr) {
+			break
+		}
+		b := &BinaryTest{
+			OpPos: p.pos,
+			Op:    BinTestOperator(p.tok),
+			X:     left,
+		}
+		p.next()
+		if b.Op == AndTest {
+			b.Y = p.testExprComp(token(b.Op), b.OpPos)
+		} else {
+			b.Y = p.testExpr(token(b.Op), b.OpPos, true)
+		}
+		if b.Y == nil {
+			p.followErrExp(b.OpPos, b.Op.String())
+		}
+		left = b
 	}
+	return left
+}
+
+// testExprComp parses a single operand of && and ||, which may be a comparison
+// between two words like "a == b".
+func (p *Parser) testExprComp(ftok token, fpos Pos) TestExpr {
 	p.got(_Newl)
-	switch p.tok {
-	case andAnd, orOr:
-	case _LitWord:
-		if p.val == "]]" {
+	left := p.testExprBase(ftok, fpos)
+	for left != nil {
+		p.got(_Newl)
+		switch p.tok {
+		case andAnd, orOr:
+			return left
+		case _LitWord:
+			if p.val == "]]" {
+				return left
+			}
+		case rdrIn, rdrOut:
+		case _EOF, rightParen:
 			return left
+		case _Lit:
+			p.curErr("test operator words must consist of a single literal")
+		default:
+			p.curErr("not a valid test operator: %v", p.tok)
 		}
-	case rdrIn, rdrOut:
-	case _EOF, rightParen:
-		return left
-	case _Lit:
-		p.curErr("test operator words must consist of a single literal")
-	default:
-		p.curErr("not a valid test operator: %v", p.tok)
+		left = p.testBinary(left)
 	}
+	return left
+}
+
+// testBinary parses the binary test operator at the current token, such as
+// "==" or "-nt", and the word following it.
+func (p *Parser) testBinary(left TestExpr) *BinaryTest {
 	if p.tok == _LitWord {
 		if p.tok = token(testBinaryOp(p.val)); p.tok == illegalTok {
+			if w, ok := left.(*Word); ok && unknownUnaryTestOp(w.Lit()) {
+				// e.g. "-X foo"; blame the operator, not its operand
+				p.posErr(w.Pos(), "not a valid test operator: %s", w.Lit())
+			}
 			p.curErr("not a valid test operator: %s", p.val)
 		}
 	}
@@ -2238,13 +3001,7 @@ func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
 	// Save the previous quoteState, since we change it in TsReMatch.
 	oldQuote := p.quote
 
-	switch b.Op {
-	case AndTest, OrTest:
-		p.next()
-		if b.Y = p.testExpr(token(b.Op), b.OpPos, false); b.Y == nil {
-			p.followErrExp(b.OpPos, b.Op.String())
-		}
-	case TsReMatch:
+	if b.Op == TsReMatch {
 		if p.lang != LangBash {
 			p.langErr(p.pos, "regex tests", LangBash)
 		}
@@ -2254,20 +3011,30 @@ func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
 		// all sorts of ways. The better fix is likely to use a stop
 		// token, like we do with heredocs.
 		p.quote = testRegexp
-		fallthrough
-	default:
-		if _, ok := b.X.(*Word); !ok {
-			p.posErr(b.OpPos, "expected %s, %s or %s after complex expr",
-				AndTest, OrTest, "]]")
-		}
-		p.next()
-		b.Y = p.followWordTok(token(b.Op), b.OpPos)
 	}
+	if _, ok := b.X.(*Word); !ok {
+		p.posErr(b.OpPos, "expected %s, %s or %s after complex expr",
+			AndTest, OrTest, "]]")
+	}
+	p.next()
+	b.Y = p.followWordTok(token(b.Op), b.OpPos)
 	p.quote = oldQuote
 	return b
 }
 
+// unknownUnaryTestOp reports whether val looks like a unary test operator such
+// as "-f", even though it isn't one.
+func unknownUnaryTestOp(val string) bool {
+	if len(val) != 2 || val[0] != '-' {
+		return false
+	}
+	r := val[1]
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
 func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
+	p.enterNested()
+	defer p.leaveNested()
 	switch p.tok {
 	case _EOF, rightParen:
 		return nil
@@ -2287,7 +3054,7 @@ func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
 	case exclMark:
 		u := &UnaryTest{OpPos: p.pos, Op: TsNot}
 		p.next()
-		if u.X = p.testExpr(token(u.Op), u.OpPos, false); u.X == nil {
+		if u.X = p.testExprComp(token(u.Op), u.OpPos); u.X == nil {
 			p.followErrExp(u.OpPos, u.Op.String())
 		}
 		return u
@@ -2312,6 +3079,254 @@ func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
 	}
 }
 
+// testCmd turns the simple command in s into a *TestCmd, if it runs the "[" or
+// "test" builtins. See the TestCmds option.
+func (p *Parser) testCmd(s *Stmt) {
+	ce, _ := s.Cmd.(*CallExpr)
+	if ce == nil || len(ce.Assigns) > 0 || p.err != nil {
+		return
+	}
+	name := ce.Args[0].Lit()
+	if name != "[" && name != "test" {
+		return
+	}
+	args := ce.Args[1:]
+	if name == "[" {
+		if len(args) == 0 || p.testCmdArg(args[len(args)-1]) != "]" {
+			// The closing "]" is just another argument, so all we
+			// know is that the command ended without one.
+			p.posErr(ce.Args[0].Pos(), `"[" must be closed by "]"`)
+			return
+		}
+		args = args[:len(args)-1]
+	}
+	tc := &TestCmd{Args: ce.Args}
+	tp := testCmdParser{p: p, args: args}
+	tc.X = tp.orExpr()
+	if len(tp.args) > 0 {
+		w := tp.args[0]
+		val := tp.val(0)
+		switch {
+		case testCmdBinaryOp(val) != 0:
+			p.followErr(w.Pos(), val, "a word")
+		case val != "":
+			p.posErr(w.Pos(), "not a valid test operator: %s", val)
+		default:
+			p.posErr(w.Pos(), "test operator words must consist of a single literal")
+		}
+	}
+	s.Cmd = tc
+}
+
+func (p *Parser) aliasClause(s *Stmt) {
+	ce, _ := s.Cmd.(*CallExpr)
+	if ce == nil || len(ce.Assigns) > 0 || p.err != nil {
+		return
+	}
+	if ce.Args[0].Lit() != "alias" {
+		return
+	}
+	ac := &AliasClause{Alias: ce.Args[0].Parts[0].(*Lit)}
+	for _, w := range ce.Args[1:] {
+		ac.Defs = append(ac.Defs, p.aliasDef(w))
+	}
+	s.Cmd = ac
+}
+
+// aliasDef structures an operand to the alias builtin. Like the builtin, it
+// splits a definition at the first "=", as long as the name before it is a
+// non-empty literal.
+func (p *Parser) aliasDef(w *Word) *Assign {
+	first, ok := w.Parts[0].(*Lit)
+	if !ok || first.Value == "" || first.Value[0] == '-' {
+		return &Assign{Naked: true, Value: w}
+	}
+	i := strings.IndexByte(first.Value, '=')
+	switch {
+	case i == 0:
+		return &Assign{Naked: true, Value: w}
+	case i < 0 && len(w.Parts) == 1:
+		return &Assign{Naked: true, Name: first}
+	case i < 0:
+		return &Assign{Naked: true, Value: w}
+	}
+	as := &Assign{Name: &Lit{
+		ValuePos: first.ValuePos,
+		ValueEnd: posAddCol(first.ValuePos, i),
+		Value:    first.Value[:i],
+	}}
+	parts := w.Parts[1:]
+	if rest := first.Value[i+1:]; rest != "" {
+		left := &Lit{
+			ValuePos: posAddCol(first.ValuePos, i+1),
+			ValueEnd: first.ValueEnd,
+			Value:    rest,
+		}
+		var conts []int
+		for _, offs := range p.conts[first] {
+			if offs > i {
+				conts = append(conts, offs-i-1)
+			}
+		}
+		if len(conts) > 0 {
+			delete(p.conts, first)
+			p.conts[left] = conts
+		}
+		parts = append([]WordPart{left}, parts...)
+	}
+	if len(parts) > 0 {
+		as.Value = &Word{Parts: parts}
+	}
+	return as
+}
+
+// testCmdArg returns the value of a test command argument after quote removal,
+// or an empty string if the value depends on expansions. Since the builtins
+// only see their arguments after expansion, "-f" and '-f' are both operators.
+func (p *Parser) testCmdArg(w *Word) string {
+	for _, wp := range w.Parts {
+		switch wp := wp.(type) {
+		case *Lit, *SglQuoted:
+		case *DblQuoted:
+			for _, wp2 := range wp.Parts {
+				if _, ok := wp2.(*Lit); !ok {
+					return ""
+				}
+			}
+		default:
+			return ""
+		}
+	}
+	b, _ := p.unquotedWordBytes(w)
+	return string(b)
+}
+
+// testCmdBinaryOp is like testBinaryOp, but for the operators supported by the
+// "[" and "test" builtins, which include "-a" and "-o" but not "=~".
+func testCmdBinaryOp(val string) BinTestOperator {
+	switch val {
+	case "-a":
+		return AndTest
+	case "-o":
+		return OrTest
+	case "<":
+		return TsBefore
+	case ">":
+		return TsAfter
+	case "=~":
+		return 0
+	}
+	return testBinaryOp(val)
+}
+
+// testCmdParser structures the operands of a test command. Unlike in "[[ ]]",
+// the operands are regular arguments, so operators are only recognised by
+// their values and the parentheses must be quoted, as in "\( a \)".
+type testCmdParser struct {
+	p    *Parser
+	args []*Word
+}
+
+func (t *testCmdParser) val(i int) string {
+	if i >= len(t.args) {
+		return ""
+	}
+	return t.p.testCmdArg(t.args[i])
+}
+
+// isBinary reports whether the argument at i is a binary operator which can be
+// used to compare two words, with an argument following it.
+func (t *testCmdParser) isBinary(i int) bool {
+	switch op := testCmdBinaryOp(t.val(i)); op {
+	case 0, AndTest, OrTest:
+		return false
+	}
+	return i+1 < len(t.args)
+}
+
+func (t *testCmdParser) orExpr() TestExpr {
+	return t.binaryExpr(OrTest, t.andExpr)
+}
+
+func (t *testCmdParser) andExpr() TestExpr {
+	return t.binaryExpr(AndTest, t.notExpr)
+}
+
+func (t *testCmdParser) binaryExpr(op BinTestOperator, next func() TestExpr) TestExpr {
+	x := next()
+	for x != nil && testCmdBinaryOp(t.val(0)) == op {
+		b := &BinaryTest{OpPos: t.args[0].Pos(), Op: op, X: x}
+		opStr := t.val(0)
+		t.args = t.args[1:]
+		if b.Y = next(); b.Y == nil {
+			t.p.followErrExp(b.OpPos, opStr)
+		}
+		x = b
+	}
+	return x
+}
+
+func (t *testCmdParser) notExpr() TestExpr {
+	// A lone "!" is a non-empty string, and "! = x" is a comparison.
+	if t.val(0) != "!" || len(t.args) == 1 || t.isBinary(1) {
+		return t.primary()
+	}
+	u := &UnaryTest{OpPos: t.args[0].Pos(), Op: TsNot}
+	t.args = t.args[1:]
+	t.p.enterNested()
+	defer t.p.leaveNested()
+	if u.X = t.notExpr(); u.X == nil {
+		t.p.followErrExp(u.OpPos, "!")
+	}
+	return u
+}
+
+func (t *testCmdParser) primary() TestExpr {
+	if len(t.args) == 0 {
+		return nil
+	}
+	if t.isBinary(1) {
+		b := &BinaryTest{
+			OpPos: t.args[1].Pos(),
+			Op:    testCmdBinaryOp(t.val(1)),
+			X:     t.args[0],
+			Y:     t.args[2],
+		}
+		if b.Op == TsMatch && t.p.lang == LangPOSIX {
+			t.p.langErr(b.OpPos, `the "==" test operator`, LangBash, LangMirBSDKorn)
+		}
+		t.args = t.args[3:]
+		return b
+	}
+	switch val := t.val(0); {
+	case val == "(" && len(t.args) > 1:
+		pe := &ParenTest{Lparen: t.args[0].Pos()}
+		t.args = t.args[1:]
+		t.p.enterNested()
+		defer t.p.leaveNested()
+		if pe.X = t.orExpr(); pe.X == nil {
+			t.p.followErrExp(pe.Lparen, "(")
+		} else if t.val(0) != ")" {
+			t.p.followErr(pe.Lparen, "(", ")")
+		} else {
+			pe.Rparen = t.args[0].Pos()
+			t.args = t.args[1:]
+		}
+		return pe
+	case len(t.args) > 1 && testUnaryOp(val) != 0 && testUnaryOp(val) != TsNot:
+		u := &UnaryTest{
+			OpPos: t.args[0].Pos(),
+			Op:    testUnaryOp(val),
+			X:     t.args[1],
+		}
+		t.args = t.args[2:]
+		return u
+	}
+	w := t.args[0]
+	t.args = t.args[1:]
+	return w
+}
+
 func (p *Parser) declClause(s *Stmt) {
 	ds := &DeclClause{Variant: p.lit(p.pos, p.val)}
 	p.next()
@@ -2358,7 +3373,12 @@ func (p *Parser) timeClause(s *Stmt) {
 	if _, ok := p.gotRsrv("-p"); ok {
 		tc.PosixFormat = true
 	}
-	tc.Stmt = p.gotStmtPipe(p.stmt(p.pos), false)
+	s2 := p.stmt(p.pos)
+	if p.lang == LangBash {
+		// Bash allows timing a negated pipeline, like "time ! foo".
+		p.gotNegation(s2)
+	}
+	tc.Stmt = p.gotStmtPipe(s2, false)
 	s.Cmd = tc
 }
 
@@ -2441,7 +3461,7 @@ loop:
 				break
 			}
 			ce.Args = append(ce.Args, p.word(
-				p.wps(p.lit(p.pos, p.val)),
+				p.wps(p.valLit()),
 			))
 			p.next()
 		case _Lit:
@@ -5,6 +5,7 @@ package syntax
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,8 +13,10 @@ import (
 	"os/exec"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/kr/pretty"
 )
@@ -31,6 +34,218 @@ func TestKeepComments(t *testing.T) {
 	singleParse(NewParser(KeepComments(true)), in, want)(t)
 }
 
+func TestKeepCommentsInline(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want *File
+	}{
+		{
+			"foo # explanation",
+			&File{Stmts: []*Stmt{{
+				Comments: []Comment{{Text: " explanation"}},
+				Cmd:      litCall("foo"),
+			}}, Last: []Comment{}},
+		},
+		{
+			"x=1 ; # note",
+			&File{Stmts: []*Stmt{{
+				Comments: []Comment{{Text: " note"}},
+				Cmd: &CallExpr{Assigns: []*Assign{{
+					Name:  lit("x"),
+					Value: litWord("1"),
+				}}},
+			}}, Last: []Comment{}},
+		},
+		{
+			"foo && # note\nbar",
+			&File{Stmts: []*Stmt{stmt(&BinaryCmd{
+				Op: AndStmt,
+				X:  litStmt("foo"),
+				Y: &Stmt{
+					Comments: []Comment{{Text: " note"}},
+					Cmd:      litCall("bar"),
+				},
+			})}},
+		},
+		{
+			"echo '#notacomment'",
+			&File{Stmts: []*Stmt{stmt(call(
+				litWord("echo"),
+				word(sglQuoted("#notacomment")),
+			))}},
+		},
+		{
+			"echo a#b ${x#prefix}",
+			&File{Stmts: []*Stmt{stmt(call(
+				litWord("echo"),
+				litWord("a#b"),
+				word(&ParamExp{
+					Param: lit("x"),
+					Exp: &Expansion{
+						Op:   RemSmallPrefix,
+						Word: litWord("prefix"),
+					},
+				}),
+			))}},
+		},
+		{
+			"echo $(foo # note\n)",
+			&File{Stmts: []*Stmt{stmt(call(
+				litWord("echo"),
+				word(&CmdSubst{Stmts: []*Stmt{{
+					Comments: []Comment{{Text: " note"}},
+					Cmd:      litCall("foo"),
+				}}, Last: []Comment{}}),
+			))}, Last: []Comment{}},
+		},
+		{
+			"echo `foo # note\n`",
+			&File{Stmts: []*Stmt{stmt(call(
+				litWord("echo"),
+				word(&CmdSubst{Stmts: []*Stmt{{
+					Comments: []Comment{{Text: " note"}},
+					Cmd:      litCall("foo"),
+				}}, Last: []Comment{}}),
+			))}, Last: []Comment{}},
+		},
+		{
+			"cat <<EOF\n#notacomment\nEOF",
+			&File{Stmts: []*Stmt{{
+				Cmd: litCall("cat"),
+				Redirs: []*Redirect{{
+					Op:   Hdoc,
+					Word: litWord("EOF"),
+					Hdoc: litWord("#notacomment\n"),
+				}},
+			}}},
+		},
+		{
+			"cat <<EOF\n$(foo # note\n)\nEOF",
+			&File{Stmts: []*Stmt{{
+				Cmd: litCall("cat"),
+				Redirs: []*Redirect{{
+					Op:   Hdoc,
+					Word: litWord("EOF"),
+					Hdoc: word(
+						&CmdSubst{Stmts: []*Stmt{{
+							Comments: []Comment{{Text: " note"}},
+							Cmd:      litCall("foo"),
+						}}, Last: []Comment{}},
+						lit("\n"),
+					),
+				}},
+			}}, Last: []Comment{}},
+		},
+	}
+	p := NewParser(KeepComments(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), singleParse(p, tc.in, tc.want))
+	}
+}
+
+func TestParseNoStmts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, shebang string
+		comments    []string
+	}{
+		{"", "", nil},
+		{"\n\n", "", nil},
+		{" \t\n\t \n", "", nil},
+		{"# a\n# b\n", "", []string{"a", "b"}},
+		{"# a\n\n  # b", "", []string{"a", "b"}},
+		{"#!/bin/sh\n", "#!/bin/sh", nil},
+		{"#!/bin/sh", "#!/bin/sh", nil},
+		{"#!/bin/sh\n# a\n", "#!/bin/sh", []string{"a"}},
+	}
+	p := NewParser(KeepComments(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Stmts) != 0 {
+				t.Fatalf("want no statements, got %d", len(f.Stmts))
+			}
+			if f.Shebang != tc.shebang {
+				t.Errorf("want shebang %q, got %q", tc.shebang, f.Shebang)
+			}
+			var got []string
+			for _, c := range append(f.HeaderComments, f.Last...) {
+				got = append(got, strings.TrimSpace(c.Text))
+			}
+			if !reflect.DeepEqual(got, tc.comments) {
+				t.Errorf("want comments %q, got %q", tc.comments, got)
+			}
+		})
+	}
+}
+
+func TestParseHeaderComments(t *testing.T) {
+	t.Parallel()
+	texts := func(cs []Comment) string {
+		var strs []string
+		for _, c := range cs {
+			strs = append(strs, strings.TrimSpace(c.Text))
+		}
+		return strings.Join(strs, ",")
+	}
+	tests := []struct {
+		in, header, first, last string
+	}{
+		{"# a\n# b\n# c\n\necho foo\n", "a,b,c", "", ""},
+		{"#!/bin/sh\n# a\n# b\n# c\n\necho foo\n", "a,b,c", "", ""},
+		{"\n# a\n# b\n\necho foo\n", "a,b", "", ""},
+		{"# a\n\n# b\necho foo\n", "a", "b", ""},
+		{"# a\n\n# b\n\necho foo\n", "a", "b", ""},
+		{"# a\n\n# b\n", "a", "", "b"},
+		// no blank line separating the comments from the statement
+		{"# a\n# b\n# c\necho foo\n", "", "a,b,c", ""},
+		{"# a\n# b\n", "", "", "a,b"},
+		{"echo foo # a\n\necho bar\n", "", "a", ""},
+		{"echo foo\n# a\n\necho bar\n", "", "", ""},
+	}
+	p := NewParser(KeepComments(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(f.HeaderComments); got != tc.header {
+				t.Errorf("want header %q, got %q", tc.header, got)
+			}
+			var first string
+			if len(f.Stmts) > 0 {
+				first = texts(f.Stmts[0].Comments)
+			}
+			if first != tc.first {
+				t.Errorf("want first statement comments %q, got %q", tc.first, first)
+			}
+			if got := texts(f.Last); got != tc.last {
+				t.Errorf("want last comments %q, got %q", tc.last, got)
+			}
+			var buf bytes.Buffer
+			if err := NewPrinter().Print(&buf, f); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimPrefix(tc.in, "\n")
+			if got := buf.String(); got != want {
+				t.Errorf("want printed %q, got %q", want, got)
+			}
+		})
+	}
+	f, err := NewParser().Parse(strings.NewReader("# a\n\necho foo\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.HeaderComments != nil {
+		t.Errorf("unexpected header comments without KeepComments")
+	}
+}
+
 func TestParseBash(t *testing.T) {
 	t.Parallel()
 	p := NewParser()
@@ -321,6 +536,448 @@ func BenchmarkParse(b *testing.B) {
 	}
 }
 
+// largeScriptChunk resembles a section of a typical build script.
+const largeScriptChunk = `
+# build the foo component
+FOO_DIR="${SRC_DIR}/foo"
+FOO_FLAGS="-O2 -Wall"
+export CC=${CC:-gcc} CFLAGS="$CFLAGS $FOO_FLAGS"
+build_foo() {
+	local target=$1 out
+	out="$BUILD_DIR/$target.o"
+	if [ ! -f "$out" ] || [ "$FOO_DIR/$target.c" -nt "$out" ]; then
+		echo "CC $target"
+		$CC $CFLAGS -c "$FOO_DIR/$target.c" -o "$out" || return 1
+	fi
+	objects+=("$out")
+}
+for f in main util parser lexer printer; do
+	build_foo "$f" >>build.log 2>&1 || exit 1
+done
+case "$(uname -s)" in
+Linux) LDFLAGS="-lrt -lpthread" ;;
+Darwin) LDFLAGS="-framework CoreFoundation" ;;
+*) echo "unsupported platform" >&2 ;;
+esac
+$CC -o foo ${objects[@]} $LDFLAGS
+install -m 0755 foo "$DESTDIR$PREFIX/bin/foo"
+`
+
+func BenchmarkParseLargeScript(b *testing.B) {
+	b.ReportAllocs()
+	src := strings.Repeat(largeScriptChunk, 100)
+	p := NewParser(KeepComments(true))
+	in := strings.NewReader(src)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Parse(in, ""); err != nil {
+			b.Fatal(err)
+		}
+		in.Reset(src)
+	}
+}
+
+func BenchmarkParseTinyScripts(b *testing.B) {
+	srcs := make([]string, 10000)
+	for i := range srcs {
+		srcs[i] = fmt.Sprintf("foo%d <<EOF\nbar $x\nEOF\n", i)
+	}
+	in := strings.NewReader("")
+	parseAll := func(b *testing.B, p func() *Parser) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, src := range srcs {
+				in.Reset(src)
+				if _, err := p().Parse(in, ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("New", func(b *testing.B) {
+		parseAll(b, func() *Parser { return NewParser() })
+	})
+	b.Run("Reuse", func(b *testing.B) {
+		p := NewParser()
+		parseAll(b, func() *Parser { return p })
+	})
+}
+
+func TestParseNoSourceRetention(t *testing.T) {
+	// Not parallel, as it measures the heap.
+	if testing.Short() {
+		t.Skip("skipping 64MiB input in short mode")
+	}
+	const bufSize = 64 << 20
+	line := []byte("echo foo_bar $baz 'qux' >out # comment\n")
+	buf := bytes.Repeat(line, bufSize/len(line))
+
+	p := NewParser(KeepComments(true))
+	files := make([]*File, 0, 5000)
+	for i := 0; i < cap(files); i++ {
+		start := (i * 97 % (len(buf) / len(line))) * len(line)
+		src := buf[start : start+3*len(line)]
+		f, err := p.Parse(bytes.NewReader(src), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	buf = nil
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > bufSize/2 {
+		t.Fatalf("%d syntax trees use %d bytes; is the %d byte source kept alive?",
+			len(files), stats.HeapAlloc, bufSize)
+	}
+	runtime.KeepAlive(files)
+	runtime.KeepAlive(p)
+}
+
+func TestParseFiles(t *testing.T) {
+	t.Parallel()
+	srcs := make(map[string][]byte)
+	for i, c := range append(fileTests, fileTestsNoPrint...) {
+		if c.Bash == nil && c.common == nil {
+			continue
+		}
+		for j, in := range c.Strs {
+			srcs[fmt.Sprintf("%03d-%d", i, j)] = []byte(in)
+		}
+	}
+	for i, in := range malformedInputs {
+		srcs[fmt.Sprintf("malformed-%03d", i)] = []byte(in)
+	}
+	files, errs := ParseFiles(srcs, 8, KeepComments(true))
+	if got, want := len(files)+len(errs), len(srcs); got != want {
+		t.Fatalf("got %d results for %d sources", got, want)
+	}
+	p := NewParser(KeepComments(true))
+	for name, src := range srcs {
+		want, wantErr := p.Parse(bytes.NewReader(src), name)
+		if got := errs[name]; fmt.Sprint(got) != fmt.Sprint(wantErr) {
+			t.Fatalf("%s: error mismatch in %q\nwant: %v\ngot:  %v",
+				name, src, wantErr, got)
+		}
+		if wantErr != nil {
+			continue
+		}
+		if got := files[name]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: syntax tree mismatch in %q\ndiff:\n%s", name, src,
+				strings.Join(pretty.Diff(want, got), "\n"))
+		}
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	t.Parallel()
+	inputs := []string{
+		"#!/bin/sh\necho foo bar\nbaz",
+		"# comment\nfoo # another\nbar",
+		"if a; then\n\tb\nelif c\nthen d\nfi",
+		"for i in a b\ndo\n\tfoo $i\ndone",
+		"case $x in\na) b ;;\n*)\n\tc\n\t;;\nesac",
+		"f() {\n\tfoo\n}\nf",
+		"foo a \\\n\tb \\\n\tc",
+		"echo $((1 +\n2)) ${x:-\\\ny}",
+		"[[ a &&\nb ]]",
+		"a=(\n\tb\n\tc\n)",
+	}
+	// nodePositions describes all the nodes in a file, along with
+	// their line and column positions.
+	nodePositions := func(f *File) []string {
+		var list []string
+		Walk(f, func(node Node) bool {
+			if node != nil {
+				pos, end := node.Pos(), node.End()
+				list = append(list, fmt.Sprintf("%T %d:%d-%d:%d", node,
+					pos.Line(), pos.Col(), end.Line(), end.Col()))
+			}
+			return true
+		})
+		return list
+	}
+	p := NewParser(KeepComments(true))
+	for i, in := range inputs {
+		// All lines with Windows endings, then only every other line.
+		allCRLF := strings.Replace(in, "\n", "\r\n", -1)
+		lines := strings.Split(in, "\n")
+		for j := 0; j < len(lines); j += 2 {
+			lines[j] += "\r"
+		}
+		mixed := strings.Join(lines, "\n")
+		for _, crlf := range []string{allCRLF, mixed} {
+			t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+				want, err := p.Parse(strings.NewReader(in), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := p.Parse(strings.NewReader(crlf), "")
+				if err != nil {
+					t.Fatalf("Unexpected error in %q: %v", crlf, err)
+				}
+				wantPos, gotPos := nodePositions(want), nodePositions(got)
+				if !reflect.DeepEqual(wantPos, gotPos) {
+					t.Fatalf("node position mismatch in %q\nwant: %q\ngot:  %q",
+						crlf, wantPos, gotPos)
+				}
+			})
+		}
+	}
+	// Carriage returns are kept within quotes and heredoc bodies.
+	in := "echo 'a\r\nb' 'c\\\r\nd' <<EOF\r\nbody\r\nEOF\r\n"
+	f, err := p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatalf("Unexpected error in %q: %v", in, err)
+	}
+	stmt := f.Stmts[0]
+	args := stmt.Cmd.(*CallExpr).Args
+	for i, want := range []string{"a\r\nb", "c\\\r\nd"} {
+		if got := args[i+1].Parts[0].(*SglQuoted).Value; got != want {
+			t.Fatalf("want single-quoted %q, got %q", want, got)
+		}
+	}
+	if got, want := stmt.Redirs[0].Hdoc.Lit(), "body\r\n"; got != want {
+		t.Fatalf("want heredoc body %q, got %q", want, got)
+	}
+}
+
+func TestParseStartPos(t *testing.T) {
+	t.Parallel()
+	// The snippet starts after "RUN " on the fourth line of a document.
+	doc := "FROM x\n\n# comment\nRUN foo \\\n\t&& bar\n"
+	offs := strings.Index(doc, "foo")
+	p := NewParser(StartPos(uint(offs), 4, 5))
+	f, err := p.Parse(strings.NewReader(doc[offs:]), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := f.Stmts[0].Cmd.(*BinaryCmd)
+	for _, tc := range []struct {
+		node Node
+		want string
+		line uint
+		col  uint
+	}{
+		{bin.X, "foo", 4, 5},
+		{bin.Y, "bar", 5, 5},
+	} {
+		pos := tc.node.Pos()
+		if pos.Line() != tc.line || pos.Col() != tc.col {
+			t.Errorf("want %s at %d:%d, got %s", tc.want, tc.line, tc.col, pos)
+		}
+		if got := string(NodeBytes([]byte(doc), tc.node)); got != tc.want {
+			t.Errorf("want %q in the document, got %q", tc.want, got)
+		}
+	}
+
+	_, err = p.Parse(strings.NewReader("foo\nbar )"), "")
+	if want := "5:5: a command can only contain words and redirects"; err == nil || err.Error() != want {
+		t.Fatalf("want error %q, got %v", want, err)
+	}
+
+	// zero values go back to the default start position
+	StartPos(0, 0, 0)(p)
+	f, err = p.Parse(strings.NewReader("foo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := f.Pos(); pos.Line() != 1 || pos.Col() != 1 || pos.Offset() != 0 {
+		t.Fatalf("want the default start position, got %s and offset %d",
+			pos, pos.Offset())
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	in := "\xEF\xBB\xBFecho hi"
+	f, err := p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &File{Stmts: []*Stmt{litStmt("echo", "hi")}}
+	clearPosRecurse(t, in, f)
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("syntax tree mismatch in %q\ndiff:\n%s", in,
+			strings.Join(pretty.Diff(want, f), "\n"))
+	}
+
+	f, err = p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lit := f.Stmts[0].Cmd.(*CallExpr).Args[0].Parts[0].(*Lit)
+	if pos := lit.Pos(); pos.Line() != 1 || pos.Col() != 1 || pos.Offset() != 3 {
+		t.Fatalf("want first literal at 1:1 and offset 3, got %s and offset %d",
+			pos, pos.Offset())
+	}
+	if got := string(NodeBytes([]byte(in), lit)); got != "echo" {
+		t.Fatalf("want first literal source %q, got %q", "echo", got)
+	}
+
+	// Only a leading byte order mark is skipped.
+	in = "echo \xEF\xBB\xBFhi"
+	f, err = p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Stmts[0].Cmd.(*CallExpr).Args[1].Lit(), "\uFEFFhi"; got != want {
+		t.Fatalf("want second argument %q, got %q", want, got)
+	}
+}
+
+func TestParseLineContinuation(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	// wordValue joins the literal parts in a word, keeping the quotes.
+	var wordValue func(parts []WordPart) string
+	wordValue = func(parts []WordPart) string {
+		var sb strings.Builder
+		for _, part := range parts {
+			switch x := part.(type) {
+			case *Lit:
+				sb.WriteString(x.Value)
+			case *SglQuoted:
+				sb.WriteString("'" + x.Value + "'")
+			case *DblQuoted:
+				sb.WriteString(`"` + wordValue(x.Parts) + `"`)
+			}
+		}
+		return sb.String()
+	}
+	tests := []struct {
+		in   string
+		want []string // words of the first statement, then heredocs
+		last string   // position of the last statement
+	}{
+		// a continuation separates words if preceded by a space
+		{"foo \\\nbar\nlast", []string{"foo", "bar"}, "3:1"},
+		{"foo \\\n\\\nbar\nlast", []string{"foo", "bar"}, "4:1"},
+		{"foo\\\n  bar\nlast", []string{"foo", "bar"}, "3:1"},
+		// but otherwise it joins them
+		{"foo\\\nbar\nlast", []string{"foobar"}, "3:1"},
+		{"echo a\\\n# not a comment\nlast", []string{"echo", "a#", "not", "a", "comment"}, "3:1"},
+		// literal within single quotes
+		{"echo 'a\\\nb'\nlast", []string{"echo", "'a\\\nb'"}, "3:1"},
+		// a continuation within double quotes
+		{"echo \"a\\\nb\"\nlast", []string{"echo", `"ab"`}, "3:1"},
+		// an escaped backslash followed by a newline isn't one
+		{"echo \\\\\nlast", []string{"echo", `\\`}, "2:1"},
+		// heredoc bodies behave like double quotes, unless quoted
+		{"cat <<EOF\na\\\nb\nEOF\nlast", []string{"cat", "ab\n"}, "5:1"},
+		{"cat <<'EOF'\na\\\nb\nEOF\nlast", []string{"cat", "a\\\nb\n"}, "5:1"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			var got []string
+			for _, w := range f.Stmts[0].Cmd.(*CallExpr).Args {
+				got = append(got, wordValue(w.Parts))
+			}
+			for _, r := range f.Stmts[0].Redirs {
+				got = append(got, wordValue(r.Hdoc.Parts))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("words mismatch in %q\nwant: %q\ngot:  %q", tc.in, tc.want, got)
+			}
+			last := f.Stmts[len(f.Stmts)-1]
+			if got := last.Pos().String(); got != tc.last {
+				t.Fatalf("want last statement at %s, got %s", tc.last, got)
+			}
+		})
+	}
+}
+
+func TestParseBquotesChunks(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	tests := []string{
+		"`echo \\`echo \\\\\\`date\\\\\\`\\``",
+		"`echo \\$x \\\\\\$y \\`echo \\\\$z\\``",
+		"`echo a\\\\`",
+		"`echo \\`echo a\\\\\\\\\\``",
+		// runs of backslashes longer than a read buffer
+		"`echo " + strings.Repeat(`\`, 3*bufSize) + "`",
+		"`echo " + strings.Repeat(`\`, 3*bufSize+1) + "$x`",
+		// a run of backslashes crossing a read boundary
+		strings.Repeat(" ", bufSize-8) + "`echo \\\\\\$x`",
+	}
+	for i, in := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			want, err := p.Parse(strings.NewReader(in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(iotest.OneByteReader(strings.NewReader(in)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatal("tree mismatch when reading one byte at a time")
+			}
+		})
+	}
+}
+
+func TestParseShebang(t *testing.T) {
+	t.Parallel()
+	p := NewParser(KeepComments(true))
+	tests := []struct {
+		in          string
+		want        string
+		stmts, coms int
+	}{
+		{"#!/usr/bin/env bash\necho hi", "#!/usr/bin/env bash", 1, 0},
+		{"#!/bin/sh -e\r\n# comment\nfoo\nbar", "#!/bin/sh -e", 2, 1},
+		{"#!/bin/sh", "#!/bin/sh", 0, 0},
+		{"#!/bin/sh \\\nfoo", "#!/bin/sh \\", 1, 0},
+		// only at the very start of the input
+		{" #!/bin/sh\nfoo", "", 1, 1},
+		{"\n#!/bin/sh\nfoo", "", 1, 1},
+		{"\xEF\xBB\xBF#!/bin/sh\nfoo", "", 1, 1},
+		{"# comment\nfoo", "", 1, 1},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			if f.Shebang != tc.want {
+				t.Fatalf("want shebang %q in %q, got %q", tc.want, tc.in, f.Shebang)
+			}
+			if len(f.Stmts) != tc.stmts {
+				t.Fatalf("want %d statements in %q, got %d", tc.stmts, tc.in, len(f.Stmts))
+			}
+			coms := len(f.Last)
+			for _, s := range f.Stmts {
+				coms += len(s.Comments)
+			}
+			if coms != tc.coms {
+				t.Fatalf("want %d comments in %q, got %d", tc.coms, tc.in, coms)
+			}
+		})
+	}
+	// Stmts has no File to record the shebang in
+	var coms []Comment
+	err := p.Stmts(strings.NewReader("#!/bin/sh\nfoo"), func(s *Stmt) bool {
+		coms = append(coms, s.Comments...)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coms) != 1 || coms[0].Text != "!/bin/sh" {
+		t.Fatalf("want the shebang as a comment with Stmts, got %#v", coms)
+	}
+}
+
 type errorCase struct {
 	in          string
 	common      interface{}
@@ -381,15 +1038,15 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   `${ `,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:4 without matching ${ with }`,
 	},
 	{
 		in:   `${ foo;`,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:8 without matching ${ with }`,
 	},
 	{
 		in:   `${ foo }`,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:9 without matching ${ with }`,
 	},
 	{
 		in:    `${|foo;}`,
@@ -398,15 +1055,15 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   `${|`,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:4 without matching ${ with }`,
 	},
 	{
 		in:   `${|foo;`,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:8 without matching ${ with }`,
 	},
 	{
 		in:   `${|foo }`,
-		mksh: `1:1: reached EOF without matching ${ with }`,
+		mksh: `1:1: reached EOF at 1:9 without matching ${ with }`,
 	},
 	{
 		in:     "((foo\x80bar",
@@ -438,14 +1095,14 @@ var shellTests = []errorCase{
 	},
 	{
 		// bash allows lone '!', unlike dash, mksh, and us.
-		in:     "! !",
-		common: `1:1: cannot negate a command multiple times`,
-		bash:   `1:1: cannot negate a command multiple times #NOERR`,
+		in:    "! !",
+		posix: `1:1: cannot negate a command multiple times`,
+		mksh:  `1:1: "!" cannot form a statement alone`,
+		bash:  `1:1: "!" cannot form a statement alone #NOERR`,
 	},
 	{
-		in:     "! ! foo",
-		common: `1:1: cannot negate a command multiple times #NOERR`,
-		posix:  `1:1: cannot negate a command multiple times`,
+		in:    "! ! foo",
+		posix: `1:1: cannot negate a command multiple times`,
 	},
 	{
 		in:     "}",
@@ -459,6 +1116,34 @@ var shellTests = []errorCase{
 		in:     "elif",
 		common: `1:1: "elif" can only be used in an if`,
 	},
+	{
+		in:     "else",
+		common: `1:1: "else" can only be used in an if`,
+	},
+	{
+		in:     "then echo hi",
+		common: `1:1: "then" can only be used in an if`,
+	},
+	{
+		in:     "foo; else bar",
+		common: `1:6: "else" can only be used in an if`,
+	},
+	{
+		in:     "foo\nfi",
+		common: `2:1: "fi" can only be used to end an if`,
+	},
+	{
+		in:     "foo && do bar",
+		common: `1:8: "do" can only be used in a loop`,
+	},
+	{
+		in:     "while a; do b; done; done",
+		common: `1:22: "done" can only be used to end a loop`,
+	},
+	{
+		in:     "{ esac; }",
+		common: `1:3: "esac" can only be used to end a case`,
+	},
 	{
 		in:     "fi",
 		common: `1:1: "fi" can only be used to end an if`,
@@ -505,15 +1190,15 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "'",
-		common: `1:1: reached EOF without closing quote '`,
+		common: `1:1: reached EOF at 1:2 without closing quote '`,
 	},
 	{
 		in:     `"`,
-		common: `1:1: reached EOF without closing quote "`,
+		common: `1:1: reached EOF at 1:2 without closing quote "`,
 	},
 	{
 		in:     `'\''`,
-		common: `1:4: reached EOF without closing quote '`,
+		common: `1:4: reached EOF at 1:5 without closing quote '`,
 	},
 	{
 		in:     ";",
@@ -534,15 +1219,35 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "{",
-		common: `1:1: reached EOF without matching { with }`,
+		common: `1:1: reached EOF at 1:2 without matching { with }`,
 	},
 	{
 		in:     "{ #}",
-		common: `1:1: reached EOF without matching { with }`,
+		common: `1:1: reached EOF at 1:5 without matching { with }`,
+	},
+	{
+		in:     "{ echo hi }",
+		common: `1:1: reached EOF at 1:12 without matching { with }; the } at 1:11 must follow ; or a newline`,
+	},
+	{
+		in:     "{ foo; bar }; baz",
+		common: `1:1: reached EOF at 1:18 without matching { with }; the } at 1:12 must follow ; or a newline`,
+	},
+	{
+		in:     "foo() { bar }",
+		common: `1:7: reached EOF at 1:14 without matching { with }; the } at 1:13 must follow ; or a newline`,
+	},
+	{
+		in:     "{\n\tfoo\n\tbar\n",
+		common: `1:1: reached EOF at 4:1 without matching { with }`,
+	},
+	{
+		in:     "if a; then\n\tb\n",
+		common: `1:1: if statement must end with "fi", reached EOF at 3:1`,
 	},
 	{
 		in:     "(",
-		common: `1:1: reached EOF without matching ( with )`,
+		common: `1:1: reached EOF at 1:2 without matching ( with )`,
 	},
 	{
 		in:     ")",
@@ -550,7 +1255,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "`",
-		common: "1:1: reached EOF without closing quote `",
+		common: "1:1: reached EOF at 1:2 without closing quote `",
 	},
 	{
 		in:     ";;",
@@ -558,7 +1263,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "( foo;",
-		common: `1:1: reached EOF without matching ( with )`,
+		common: `1:1: reached EOF at 1:7 without matching ( with )`,
 	},
 	{
 		in:     "&",
@@ -606,23 +1311,23 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "foo'",
-		common: `1:4: reached EOF without closing quote '`,
+		common: `1:4: reached EOF at 1:5 without closing quote '`,
 	},
 	{
 		in:     `foo"`,
-		common: `1:4: reached EOF without closing quote "`,
+		common: `1:4: reached EOF at 1:5 without closing quote "`,
 	},
 	{
 		in:     `"foo`,
-		common: `1:1: reached EOF without closing quote "`,
+		common: `1:1: reached EOF at 1:5 without closing quote "`,
 	},
 	{
 		in:     `"foobar\`,
-		common: `1:1: reached EOF without closing quote "`,
+		common: `1:1: reached EOF at 1:9 without closing quote "`,
 	},
 	{
 		in:     `"foo\a`,
-		common: `1:1: reached EOF without closing quote "`,
+		common: `1:1: reached EOF at 1:7 without closing quote "`,
 	},
 	{
 		in:     "foo()",
@@ -631,7 +1336,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "foo() {",
-		common: `1:7: reached EOF without matching { with }`,
+		common: `1:7: reached EOF at 1:8 without matching { with }`,
 	},
 	{
 		in:    "foo-bar() { x; }",
@@ -782,11 +1487,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "if true; then bar;",
-		common: `1:1: if statement must end with "fi"`,
+		common: `1:1: if statement must end with "fi", reached EOF at 1:19`,
 	},
 	{
 		in:     "if true; then bar; fi#etc",
-		common: `1:1: if statement must end with "fi"`,
+		common: `1:1: if statement must end with "fi", reached EOF at 1:26`,
 	},
 	{
 		in:     "if a; then b; elif c;",
@@ -794,11 +1499,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "'foo' '",
-		common: `1:7: reached EOF without closing quote '`,
+		common: `1:7: reached EOF at 1:8 without closing quote '`,
 	},
 	{
 		in:     "'foo\n' '",
-		common: `2:3: reached EOF without closing quote '`,
+		common: `2:3: reached EOF at 2:4 without closing quote '`,
 	},
 	{
 		in:     "while",
@@ -810,11 +1515,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "while true; do bar",
-		common: `1:1: while statement must end with "done"`,
+		common: `1:1: while statement must end with "done", reached EOF at 1:19`,
 	},
 	{
 		in:     "while true; do bar;",
-		common: `1:1: while statement must end with "done"`,
+		common: `1:1: while statement must end with "done", reached EOF at 1:20`,
 	},
 	{
 		in:     "until",
@@ -826,11 +1531,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "until true; do bar",
-		common: `1:1: until statement must end with "done"`,
+		common: `1:1: until statement must end with "done", reached EOF at 1:19`,
 	},
 	{
 		in:     "until true; do bar;",
-		common: `1:1: until statement must end with "done"`,
+		common: `1:1: until statement must end with "done", reached EOF at 1:20`,
 	},
 	{
 		in:     "for",
@@ -858,7 +1563,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "for i in 1 2 3; do echo $i;",
-		common: `1:1: for statement must end with "done"`,
+		common: `1:1: for statement must end with "done", reached EOF at 1:28`,
 	},
 	{
 		in:     "for i in 1 2 3; echo $i;",
@@ -890,7 +1595,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "select i in 1 2 3; do echo $i;",
-		bsmk: `1:1: select statement must end with "done"`,
+		bsmk: `1:1: select statement must end with "done", reached EOF at 1:31`,
 	},
 	{
 		in:   "select i in 1 2 3; echo $i;",
@@ -910,31 +1615,31 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "echo $(foo",
-		common: `1:6: reached EOF without matching ( with )`,
+		common: `1:6: reached EOF at 1:11 without matching ( with )`,
 	},
 	{
 		in:     "echo $((foo",
-		common: `1:6: reached EOF without matching $(( with ))`,
+		common: `1:6: reached EOF at 1:12 without matching $(( with ))`,
 	},
 	{
 		in:     `echo $((\`,
-		common: `1:6: reached EOF without matching $(( with ))`,
+		common: `1:6: reached EOF at 1:10 without matching $(( with ))`,
 	},
 	{
 		in:     `echo $((o\`,
-		common: `1:6: reached EOF without matching $(( with ))`,
+		common: `1:6: reached EOF at 1:11 without matching $(( with ))`,
 	},
 	{
 		in:     `echo $((foo\a`,
-		common: `1:6: reached EOF without matching $(( with ))`,
+		common: `1:6: reached EOF at 1:14 without matching $(( with ))`,
 	},
 	{
 		in:     `echo $(($(a"`,
-		common: `1:12: reached EOF without closing quote "`,
+		common: `1:12: reached EOF at 1:13 without closing quote "`,
 	},
 	{
 		in:     "echo $((`echo 0`",
-		common: `1:6: reached EOF without matching $(( with ))`,
+		common: `1:6: reached EOF at 1:17 without matching $(( with ))`,
 	},
 	{
 		in:     `echo $((& $(`,
@@ -958,7 +1663,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "echo $(((3))",
-		common: `1:6: reached ) without matching $(( with ))`,
+		common: `1:6: reached ) at 1:12 without matching $(( with ))`,
 	},
 	{
 		in:     "echo $((+))",
@@ -974,7 +1679,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo $((foo) )",
-		bsmk: `1:6: reached ) without matching $(( with )) #NOERR`,
+		bsmk: `1:6: reached ) at 1:12 without matching $(( with )) #NOERR`,
 	},
 	{
 		in:     "echo $((a *))",
@@ -1027,11 +1732,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "<<EOF\n`))",
-		common: `2:2: ) can only be used to close a subshell`,
+		common: `2:2: )) can only be used to close an arithmetic expression`,
 	},
 	{
 		in:     "echo ${foo",
-		common: `1:6: reached EOF without matching ${ with }`,
+		common: `1:6: reached EOF at 1:11 without matching ${ with }`,
 	},
 	{
 		in:     "echo $foo ${}",
@@ -1047,11 +1752,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "echo ${foo-bar",
-		common: `1:6: reached EOF without matching ${ with }`,
+		common: `1:6: reached EOF at 1:15 without matching ${ with }`,
 	},
 	{
 		in:     "#foo\n{",
-		common: `2:1: reached EOF without matching { with }`,
+		common: `2:1: reached EOF at 2:2 without matching { with }`,
 	},
 	{
 		in:     `echo "foo${bar"`,
@@ -1064,7 +1769,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "echo ${##",
-		common: `1:6: reached EOF without matching ${ with }`,
+		common: `1:6: reached EOF at 1:10 without matching ${ with }`,
 	},
 	{
 		in:     "echo ${#<}",
@@ -1134,6 +1839,10 @@ var shellTests = []errorCase{
 		in:   "echo ${#foo:-bar}",
 		bsmk: `1:12: cannot combine multiple parameter expansion operators`,
 	},
+	{
+		in:     "echo ${#!foo}",
+		common: `1:10: ! cannot be followed by a word`,
+	},
 	{
 		in:   "echo ${%foo:1:3}",
 		mksh: `1:12: cannot combine multiple parameter expansion operators`,
@@ -1172,7 +1881,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "case i in 3) foo;",
-		common: `1:1: case statement must end with "esac"`,
+		common: `1:1: case statement must end with "esac", reached EOF at 1:18`,
 	},
 	{
 		in:     "case i in 3) foo; 4) bar; esac",
@@ -1189,27 +1898,27 @@ var shellTests = []errorCase{
 	{
 		in:     "case i {",
 		common: `1:1: "case i {" is a mksh feature`,
-		mksh:   `1:1: case statement must end with "}"`,
+		mksh:   `1:1: case statement must end with "}", reached EOF at 1:9`,
 	},
 	{
 		in:   "case i { x) y ;;",
-		mksh: `1:1: case statement must end with "}"`,
+		mksh: `1:1: case statement must end with "}", reached EOF at 1:17`,
 	},
 	{
 		in:     "\"`\"",
-		common: `1:3: reached EOF without closing quote "`,
+		common: `1:3: reached EOF at 1:4 without closing quote "`,
 	},
 	{
 		in:     "`\"`",
-		common: "1:3: reached EOF without closing quote `",
+		common: "1:3: reached EOF at 1:4 without closing quote `",
 	},
 	{
 		in:     "`\\```",
-		common: "1:2: reached EOF without closing quote `",
+		common: "1:2: reached EOF at 1:3 without closing quote `",
 	},
 	{
 		in:     "`{\n`",
-		common: "1:2: reached ` without matching { with }",
+		common: "1:2: reached ` at 2:1 without matching { with }",
 	},
 	{
 		in:    "echo \"`)`\"",
@@ -1263,8 +1972,8 @@ var shellTests = []errorCase{
 	},
 	{
 		in:    "((foo",
-		bsmk:  `1:1: reached EOF without matching (( with ))`,
-		posix: `1:2: reached EOF without matching ( with )`,
+		bsmk:  `1:1: reached EOF at 1:6 without matching (( with ))`,
+		posix: `1:2: reached EOF at 1:6 without matching ( with )`,
 	},
 	{
 		in:   "(())",
@@ -1358,7 +2067,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "[[ a",
-		bsmk: `1:1: reached EOF without matching [[ with ]]`,
+		bsmk: `1:1: reached EOF at 1:5 without matching [[ with ]]`,
 	},
 	{
 		in:   "[[ a ||",
@@ -1375,7 +2084,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "[[ -f a",
-		bsmk: `1:1: reached EOF without matching [[ with ]]`,
+		bsmk: `1:1: reached EOF at 1:8 without matching [[ with ]]`,
 	},
 	{
 		in:   "[[ -n\na ]]",
@@ -1383,16 +2092,28 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "[[ a -nt b",
-		bsmk: `1:1: reached EOF without matching [[ with ]]`,
+		bsmk: `1:1: reached EOF at 1:11 without matching [[ with ]]`,
 	},
 	{
 		in:   "[[ a =~ b",
-		bash: `1:1: reached EOF without matching [[ with ]]`,
+		bash: `1:1: reached EOF at 1:10 without matching [[ with ]]`,
 	},
 	{
 		in:   "[[ a b c ]]",
 		bsmk: `1:6: not a valid test operator: b`,
 	},
+	{
+		in:   "[[ -X foo ]]",
+		bsmk: `1:4: not a valid test operator: -X`,
+	},
+	{
+		in:   "[[ -f a && -Q b ]]",
+		bsmk: `1:12: not a valid test operator: -Q`,
+	},
+	{
+		in:   "[[ -R foo ]]",
+		mksh: `1:4: not a valid test operator: -R`,
+	},
 	{
 		in:   "[[ a b$x c ]]",
 		bsmk: `1:6: test operator words must consist of a single literal`,
@@ -1431,7 +2152,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "[[ a =~ ())",
-		bash: `1:1: reached ) without matching [[ with ]]`,
+		bash: `1:1: reached ) at 1:11 without matching [[ with ]]`,
 	},
 	{
 		in:   "[[ >",
@@ -1455,7 +2176,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "a=([i)",
-		bash: `1:4: reached ) without matching [ with ]`,
+		bash: `1:4: reached ) at 1:6 without matching [ with ]`,
 	},
 	{
 		in:   "a=([i])",
@@ -1519,7 +2240,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "a[b",
-		bsmk: `1:2: reached EOF without matching [ with ]`,
+		bsmk: `1:2: reached EOF at 1:4 without matching [ with ]`,
 	},
 	{
 		in:   "a[]",
@@ -1535,7 +2256,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo $((a[b))",
-		bsmk: `1:10: reached ) without matching [ with ]`,
+		bsmk: `1:10: reached ) at 1:12 without matching [ with ]`,
 	},
 	{
 		in:   "echo $((a[]))",
@@ -1560,43 +2281,50 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo $[foo",
-		bash: `1:6: reached EOF without matching $[ with ]`,
+		bash: `1:6: reached EOF at 1:11 without matching $[ with ]`,
 	},
 	{
 		in:   "echo $'",
-		bsmk: `1:6: reached EOF without closing quote '`,
+		bsmk: `1:6: reached EOF at 1:8 without closing quote '`,
 	},
 	{
 		in:   `echo $"`,
-		bsmk: `1:6: reached EOF without closing quote "`,
+		bsmk: `1:6: reached EOF at 1:8 without closing quote "`,
 	},
 	{
 		in:   "echo @(",
-		bsmk: `1:6: reached EOF without matching @( with )`,
+		bsmk: `1:6: reached EOF at 1:8 without matching @( with )`,
 	},
 	{
 		in:   "echo @(a",
-		bsmk: `1:6: reached EOF without matching @( with )`,
+		bsmk: `1:6: reached EOF at 1:9 without matching @( with )`,
 	},
 	{
 		in:   "((@(",
-		bsmk: `1:1: reached ( without matching (( with ))`,
+		bsmk: `1:1: reached ( at 1:4 without matching (( with ))`,
 	},
 	{
 		in:   "time {",
-		bsmk: `1:6: reached EOF without matching { with }`,
+		bsmk: `1:6: reached EOF at 1:7 without matching { with }`,
 	},
 	{
 		in:   "time ! foo",
-		bash: `1:6: "!" can only be used in full statements #NOERR wrong`,
 		mksh: `1:6: "!" can only be used in full statements`,
 	},
 	{
-		in:   "coproc",
-		bash: `1:1: coproc clause requires a command`,
+		in:   "time !",
+		bash: `1:6: "!" cannot form a statement alone`,
 	},
 	{
-		in:   "coproc\n$",
+		in:   "time foo | ! bar",
+		bash: `1:12: "!" can only be used in full statements`,
+	},
+	{
+		in:   "coproc",
+		bash: `1:1: coproc clause requires a command`,
+	},
+	{
+		in:   "coproc\n$",
 		bash: `1:1: coproc clause requires a command`,
 	},
 	{
@@ -1622,11 +2350,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo ${a/\n",
-		bsmk: `1:6: reached EOF without matching ${ with }`,
+		bsmk: `1:6: reached EOF at 2:1 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${a-\n",
-		bsmk: `1:6: reached EOF without matching ${ with }`,
+		bsmk: `1:6: reached EOF at 2:1 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${foo:",
@@ -1638,7 +2366,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo ${foo:1",
-		bsmk: `1:6: reached EOF without matching ${ with }`,
+		bsmk: `1:6: reached EOF at 1:13 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${foo:1:",
@@ -1646,11 +2374,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo ${foo:1:2",
-		bsmk: `1:6: reached EOF without matching ${ with }`,
+		bsmk: `1:6: reached EOF at 1:15 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${foo,",
-		bash: `1:6: reached EOF without matching ${ with }`,
+		bash: `1:6: reached EOF at 1:12 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${foo@",
@@ -1662,7 +2390,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:   "echo ${foo@Q",
-		bash: `1:6: reached EOF without matching ${ with }`,
+		bash: `1:6: reached EOF at 1:13 without matching ${ with }`,
 	},
 	{
 		in:   "echo ${foo@bar}",
@@ -1680,17 +2408,37 @@ var shellTests = []errorCase{
 		in:   `((echo a); (echo b))`,
 		bsmk: `1:8: not a valid arithmetic operator: a #NOERR backtrack`,
 	},
+	{
+		in:   `((echo hi) )`,
+		bsmk: `1:8: not a valid arithmetic operator: hi #NOERR backtrack`,
+	},
+	{
+		in:   `((echo hi))`,
+		bsmk: `1:8: not a valid arithmetic operator: hi #NOERR at runtime`,
+	},
 	{
 		in:   "for ((;;",
-		bash: `1:5: reached EOF without matching (( with ))`,
+		bash: `1:5: reached EOF at 1:9 without matching (( with ))`,
 	},
 	{
 		in:   "for ((;;0000000",
-		bash: `1:5: reached EOF without matching (( with ))`,
+		bash: `1:5: reached EOF at 1:16 without matching (( with ))`,
 	},
 	{
 		in:    "function foo() { bar; }",
-		posix: `1:13: a command can only contain words and redirects`,
+		posix: `1:1: the "function" keyword is a bash/mksh feature`,
+	},
+	{
+		in:    "function foo { bar; }",
+		posix: `1:1: the "function" keyword is a bash/mksh feature`,
+	},
+	{
+		in:    "[[ a ]]",
+		posix: `1:1: [[ tests are a bash/mksh feature`,
+	},
+	{
+		in:    "foo && ! [[ -n $a ]]",
+		posix: `1:10: [[ tests are a bash/mksh feature`,
 	},
 	{
 		in:    "echo <(",
@@ -1748,7 +2496,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "a=$c\n'",
-		common: `2:1: reached EOF without closing quote '`,
+		common: `2:1: reached EOF at 2:2 without closing quote '`,
 	},
 	{
 		in:    "echo ${!foo}",
@@ -1777,7 +2525,7 @@ var shellTests = []errorCase{
 	},
 	{
 		in:     "`\"`\\",
-		common: "1:3: reached EOF without closing quote `",
+		common: "1:3: reached EOF at 1:5 without closing quote `",
 	},
 }
 
@@ -1854,10 +2602,337 @@ func TestParseErrMirBSDKorn(t *testing.T) {
 	}
 }
 
+// malformedInputs are inputs which used to be problematic for fuzzers, or
+// which cut off the parser at awkward points. All of them must result in
+// an error in every language variant, except for the bash-only ones.
+var malformedInputs = []string{
+	"${", "${#", "${#[", "${x[", "${x[1", "${x:", "${x/", "${!",
+	"$(", "$((", "$((1", "$((x[", "`", "`$(`",
+	"a=(", "a=([", "a=([x]",
+	"<<", "<<EOF\n$(", "<<'", "$'", `$"`, `"$(`, "'",
+	"((", "[[ (", "case", "case x in",
+	"if", "for", "for (( ;", "while a; do", "{", "(", "f(",
+}
+
+var malformedInputsBash = []string{
+	"$[", "a[", "a[1", "[[", "[[ a", "[[ -f",
+}
+
+func TestParseMalformed(t *testing.T) {
+	t.Parallel()
+	for _, lang := range []LangVariant{LangBash, LangPOSIX, LangMirBSDKorn} {
+		p := NewParser(KeepComments(true), Variant(lang))
+		inputs := malformedInputs
+		if lang == LangBash {
+			inputs = append(inputs, malformedInputsBash...)
+		}
+		for i, in := range inputs {
+			t.Run(fmt.Sprintf("%s-%03d", lang, i), func(t *testing.T) {
+				if _, err := p.Parse(strings.NewReader(in), ""); err == nil {
+					t.Fatalf("Expected error in %q", in)
+				}
+			})
+		}
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	t.Parallel()
+	nested := func(open, mid, close string, n int) string {
+		return strings.Repeat(open, n) + mid + strings.Repeat(close, n)
+	}
+	tests := []struct {
+		in      func(n int) string
+		wantErr string
+	}{
+		{
+			func(n int) string { return nested("(", "a", ")", n) },
+			"nesting too deep",
+		},
+		{
+			func(n int) string { return nested("$(", "a", ")", n) },
+			"nesting too deep",
+		},
+		{
+			func(n int) string { return "echo $((" + nested("(", "1", ")", n) + "))" },
+			"nesting too deep",
+		},
+		{
+			func(n int) string { return "[[ " + nested("( ", "a", " )", n) + " ]]" },
+			"nesting too deep",
+		},
+		{
+			func(n int) string { return nested("${a:-", "b", "}", n) },
+			"nesting too deep",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser()
+			// well past the default limit
+			_, err := p.Parse(strings.NewReader(tc.in(100000)), "")
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("want a %q error, got: %v", tc.wantErr, err)
+			}
+			if _, err := p.Parse(strings.NewReader(tc.in(100)), ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			MaxDepth(50)(p)
+			_, err = p.Parse(strings.NewReader(tc.in(100)), "")
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("want a %q error, got: %v", tc.wantErr, err)
+			}
+			if _, err := p.Parse(strings.NewReader(tc.in(10)), ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseMaxSize(t *testing.T) {
+	t.Parallel()
+	tests := []string{
+		strings.Repeat("echo foo\n", 1000),
+		"echo '" + strings.Repeat("x", 10000) + "'",
+		"echo $((" + strings.Repeat("1+", 10000) + "1))",
+	}
+	for i, in := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser()
+			if _, err := p.Parse(strings.NewReader(in), ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, size := range []int{1, 100, len(in) - 1} {
+				MaxSize(size)(p)
+				_, err := p.Parse(strings.NewReader(in), "")
+				want := fmt.Sprintf("input too large: over %d bytes", size)
+				if err == nil || !strings.HasSuffix(err.Error(), want) {
+					t.Fatalf("want a %q error, got: %v", want, err)
+				}
+				if offs := err.(ParseError).Pos.Offset(); offs > uint(size) {
+					t.Fatalf("want the error within %d bytes, got offset %d", size, offs)
+				}
+			}
+			MaxSize(len(in))(p)
+			if _, err := p.Parse(strings.NewReader(in), ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+	// the error is at the limit
+	p := NewParser(MaxSize(2000))
+	_, err := p.Parse(strings.NewReader(strings.Repeat("echo foo\n", 300)), "")
+	perr, ok := err.(ParseError)
+	if !ok {
+		t.Fatalf("want a ParseError, got %T: %v", err, err)
+	}
+	if got := perr.Pos; got.Line() != 223 || got.Col() != 3 || got.Offset() != 2000 {
+		t.Fatalf("want the error at 223:3 and offset 2000, got %s and offset %d",
+			got, got.Offset())
+	}
+}
+
+// cancelReader cancels a context once a number of bytes have been read.
+type cancelReader struct {
+	r      io.Reader
+	read   int
+	after  int
+	cancel func()
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.read += n; r.read >= r.after {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestParseContext(t *testing.T) {
+	t.Parallel()
+	const stmts = 100000
+	in := strings.Repeat("echo foo\n", stmts)
+	p := NewParser()
+	f, err := p.ParseContext(context.Background(), strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Stmts) != stmts {
+		t.Fatalf("want %d statements, got %d", stmts, len(f.Stmts))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelReader{r: strings.NewReader(in), after: 10000, cancel: cancel}
+	f, err = p.ParseContext(ctx, r, "")
+	if err != context.Canceled {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+	if r.read > r.after+2*bufSize {
+		t.Fatalf("want the parser to stop reading soon after %d bytes, read %d", r.after, r.read)
+	}
+	if n := len(f.Stmts); n == 0 || n >= stmts {
+		t.Fatalf("want some of the statements to be parsed, got %d", n)
+	}
+
+	// the context is not kept for the next parse
+	if _, err := p.Parse(strings.NewReader(in), ""); err != nil {
+		t.Fatal(err)
+	}
+	f, err = p.ParseContext(ctx, strings.NewReader(in), "")
+	if err != context.Canceled || len(f.Stmts) != 0 {
+		t.Fatalf("want %v and no statements with a cancelled context, got %v and %d",
+			context.Canceled, err, len(f.Stmts))
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want ParseStats
+	}{
+		{"", ParseStats{Nodes: 1}},
+		{"echo foo", ParseStats{Nodes: 7, MaxDepth: 1}},
+		{"(echo foo)", ParseStats{Nodes: 9, MaxDepth: 2}},
+		{"a $(b $(c))", ParseStats{Nodes: 17, MaxDepth: 3}},
+		{"cat <<EOF\nfoo\nEOF\ncat <<-EOF <<<bar\n\tfoo\nEOF", ParseStats{Nodes: 22, MaxDepth: 1, Heredocs: 2}},
+		{"echo $(", ParseStats{MaxDepth: 1}},
+	}
+	var stats ParseStats
+	p := NewParser(Stats(&stats))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p.Parse(strings.NewReader(tc.in), "")
+			stats.Elapsed = 0 // not deterministic
+			if stats != tc.want {
+				t.Fatalf("want stats %+v, got %+v", tc.want, stats)
+			}
+		})
+	}
+}
+
+func TestParseCaseItemOps(t *testing.T) {
+	t.Parallel()
+	type item struct {
+		op       CaseOperator
+		opPos    string
+		pos, end string
+		stmts    int
+	}
+	tests := []struct {
+		in    string
+		items []item
+		esac  string
+	}{
+		{"case x in a) foo\nesac", []item{{Break, "0:0", "1:11", "1:17", 1}}, "2:1"},
+		{"case x in a) foo;; esac", []item{{Break, "1:17", "1:11", "1:19", 1}}, "1:20"},
+		{"case x in a) ;; esac", []item{{Break, "1:14", "1:11", "1:16", 0}}, "1:17"},
+		{"case x in a) esac", []item{{Break, "0:0", "1:11", "1:13", 0}}, "1:14"},
+		{"case x in a ) esac", []item{{Break, "0:0", "1:11", "1:14", 0}}, "1:15"},
+		{"case x in (a | b\\\n) esac", []item{{Break, "0:0", "1:11", "2:2", 0}}, "2:3"},
+		{"case x in a) foo;& esac", []item{{Fallthrough, "1:17", "1:11", "1:19", 1}}, "1:20"},
+		{
+			"case x in\na) foo;;\nb) ;;\nc) bar\nesac",
+			[]item{
+				{Break, "2:7", "2:1", "2:9", 1},
+				{Break, "3:4", "3:1", "3:6", 0},
+				{Break, "0:0", "4:1", "4:7", 1},
+			},
+			"5:1",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := NewParser().Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc := f.Stmts[0].Cmd.(*CaseClause)
+			var got []item
+			for _, ci := range cc.Items {
+				got = append(got, item{
+					ci.Op, ci.OpPos.String(),
+					ci.Pos().String(), ci.End().String(),
+					len(ci.Stmts),
+				})
+			}
+			if !reflect.DeepEqual(got, tc.items) {
+				t.Fatalf("want items %v, got %v", tc.items, got)
+			}
+			if got := cc.Esac.String(); got != tc.esac {
+				t.Fatalf("want esac at %s, got %s", tc.esac, got)
+			}
+		})
+	}
+}
+
+func TestParseCaseItemLparen(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, lparen, pos string
+	}{
+		{"case x in a|b) foo;; esac", "0:0", "1:11"},
+		{"case x in (a|b) foo;; esac", "1:11", "1:11"},
+		{"case x in ( a|b) foo;; esac", "1:11", "1:11"},
+		{"case x in\n\t(a|b) foo;;\nesac", "2:2", "2:2"},
+	}
+	p := NewParser()
+	var first *CaseItem
+	for i, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ci := f.Stmts[0].Cmd.(*CaseClause).Items[0]
+		if got := ci.Lparen.String(); got != tc.lparen {
+			t.Errorf("%02d: want Lparen %s, got %s", i, tc.lparen, got)
+		}
+		if got := ci.Pos().String(); got != tc.pos {
+			t.Errorf("%02d: want Pos %s, got %s", i, tc.pos, got)
+		}
+		clearPosRecurse(t, tc.in, ci)
+		if first == nil {
+			first = ci
+		} else if !reflect.DeepEqual(ci, first) {
+			t.Errorf("%02d: want the same case item as without parentheses", i)
+		}
+	}
+}
+
+// TestParseTruncated checks that the parser doesn't panic when any of the
+// test inputs is cut off at any byte, which is a cheap way to hit many of
+// the edge cases that fuzzers find.
+func TestParseTruncated(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	for _, lang := range []LangVariant{LangBash, LangPOSIX, LangMirBSDKorn} {
+		p := NewParser(KeepComments(true), Variant(lang))
+		for _, c := range append(fileTests, fileTestsNoPrint...) {
+			for _, in := range c.Strs {
+				for i := range in {
+					func() {
+						defer func() {
+							if r := recover(); r != nil {
+								t.Fatalf("%s: panic parsing %q: %v",
+									lang, in[:i], r)
+							}
+						}()
+						p.Parse(strings.NewReader(in[:i]), "")
+					}()
+				}
+			}
+		}
+	}
+}
+
 func TestInputName(t *testing.T) {
 	t.Parallel()
 	in := "("
-	want := "some-file.sh:1:1: reached EOF without matching ( with )"
+	want := "some-file.sh:1:1: reached EOF at 1:2 without matching ( with )"
 	p := NewParser()
 	_, err := p.Parse(strings.NewReader(in), "some-file.sh")
 	if err == nil {
@@ -1870,6 +2945,36 @@ func TestInputName(t *testing.T) {
 	}
 }
 
+func TestParseErrorOffset(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, tok string
+	}{
+		{"foo; ;", ";"},
+		{"echo foo\nbar )", ")"},
+		{"éé; done", "done"},
+		{"if a; then\n\tb\nfi }", "}"},
+		{"foo \"bar", "\""},
+		{"case x in\n  y) z ;;\nesac esac", "esac"},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		_, err := p.Parse(strings.NewReader(tc.in), "")
+		perr, ok := err.(ParseError)
+		if !ok {
+			t.Fatalf("Expected ParseError in %q, got %v", tc.in, err)
+		}
+		want := strings.LastIndex(tc.in, tc.tok)
+		if tc.tok == "\"" {
+			want = strings.Index(tc.in, tc.tok)
+		}
+		if got := int(perr.Pos.Offset()); got != want {
+			t.Errorf("Wrong offset in %q: want %d, got %d (%v)",
+				tc.in, want, got, err)
+		}
+	}
+}
+
 var errBadReader = fmt.Errorf("write: expected error")
 
 type badReader struct{}
@@ -2086,7 +3191,7 @@ func TestParseDocumentError(t *testing.T) {
 	in := "foo $("
 	p := NewParser()
 	_, err := p.Document(strings.NewReader(in))
-	want := "1:5: reached EOF without matching ( with )"
+	want := "1:5: reached EOF at 1:7 without matching ( with )"
 	got := fmt.Sprintf("%v", err)
 	if got != want {
 		t.Fatalf("Expected %q as an error, but got %q", want, got)
@@ -2142,6 +3247,778 @@ func TestParseArithmeticError(t *testing.T) {
 	}
 }
 
+func TestParsePartial(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"foo\nbar baz\nif; then\nqux", []string{"foo", "bar baz"}},
+		{"foo; bar &&\n)\nqux", []string{"foo;"}},
+		{"a\n{ b; c\nd", []string{"a"}},
+		{"a\necho $(b; c\nd", []string{"a"}},
+		{"a; b )", []string{"a;"}},
+		{"}", nil},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err == nil {
+				t.Fatalf("want an error in %q", tc.in)
+			}
+			if f == nil {
+				t.Fatalf("want a partial file in %q, got nil", tc.in)
+			}
+			var got []string
+			for _, s := range f.Stmts {
+				got = append(got, string(NodeBytes([]byte(tc.in), s)))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want statements %q in %q, got %q", tc.want, tc.in, got)
+			}
+		})
+	}
+}
+
+func TestParseArithmUnterminated(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want   string
+		incomplete bool
+	}{
+		{"echo $(( 1 + 2", "1:6: reached EOF at 1:15 without matching $(( with ))", true},
+		{"echo $(( 1 + 2 )", "1:6: reached ) at 1:16 without matching $(( with ))", false},
+		{"x=$((\n1 +\n2", "1:3: reached EOF at 3:2 without matching $(( with ))", true},
+		{"(( 1 + 2", "1:1: reached EOF at 1:9 without matching (( with ))", true},
+		{"foo && ((x++", "1:8: reached EOF at 1:13 without matching (( with ))", true},
+		{"echo $[ 1 + 2", "1:6: reached EOF at 1:14 without matching $[ with ]", true},
+		{"))", "1:1: )) can only be used to close an arithmetic expression", false},
+		{"foo; ))", "1:6: )) can only be used to close an arithmetic expression", false},
+		{") )", "1:1: ) can only be used to close a subshell", false},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		_, err := p.Parse(strings.NewReader(tc.in), "")
+		perr, ok := err.(ParseError)
+		if !ok {
+			t.Fatalf("want a ParseError in %q, got %v", tc.in, err)
+		}
+		if got := perr.Error(); got != tc.want {
+			t.Errorf("want error %q in %q, got %q", tc.want, tc.in, got)
+		}
+		if perr.Incomplete != tc.incomplete {
+			t.Errorf("want Incomplete %t in %q, got %t", tc.incomplete, tc.in, perr.Incomplete)
+		}
+	}
+}
+
+func TestParseCheckArithmNumbers(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"echo $(( 08 ))", `1:10: invalid octal number: 08`},
+		{"echo $(( 0xZ ))", `1:10: invalid hexadecimal number: 0xZ`},
+		{"echo $(( 0x ))", `1:10: invalid hexadecimal number: 0x`},
+		{"echo $(( 1 + 12a ))", `1:14: invalid number: 12a`},
+		{"let x=09", `1:7: invalid octal number: 09`},
+		{"echo $(( 0 + 10 + 017 + 0xfF + 0XA ))", ""},
+		{"echo $(( x + a1 + 16#ff ))", ""},
+		{"echo $(( 2#1010 + 8#17 + 16#fF + 36#Zz + 64#zZ@_ ))", ""},
+		{"echo $(( 2#102 ))", `1:10: invalid base 2 number: 2#102`},
+		{"echo $(( 16#fg ))", `1:10: invalid base 16 number: 16#fg`},
+		{"echo $(( 37#@ ))", `1:10: invalid base 37 number: 37#@`},
+		{"echo $(( 10# ))", `1:10: invalid base 10 number: 10#`},
+		{"echo $(( 1#0 ))", `1:10: invalid arithmetic base: 1#0`},
+		{"echo $(( 65#1 ))", `1:10: invalid arithmetic base: 65#1`},
+		{"echo $(( 0x#1 ))", `1:10: invalid arithmetic base: 0x#1`},
+		{"echo $(( 99999999999999999999 ))", ""},
+	}
+	p := NewParser(CheckArithmNumbers(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			_, err := p.Parse(strings.NewReader(tc.in), "")
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tc.want {
+				t.Fatalf("Expected %q as an error in %q, but got %q",
+					tc.want, tc.in, got)
+			}
+		})
+	}
+	// numbers aren't checked by default
+	if _, err := NewParser().Parse(strings.NewReader("echo $(( 08 ))"), ""); err != nil {
+		t.Fatalf("Unexpected error without CheckArithmNumbers: %v", err)
+	}
+}
+
+func TestParseExtraKeywords(t *testing.T) {
+	t.Parallel()
+	p := NewParser(ExtraKeywords("repeat", "defer"))
+	f, err := p.Parse(strings.NewReader(
+		"repeat 3 foo; echo repeat\ndefer >out rm x; 'defer' y; a=b repeat"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, true, false, false}
+	for i, stmt := range f.Stmts {
+		if got := stmt.Cmd.(*CallExpr).Keyword; got != want[i] {
+			t.Errorf("stmt %d: want Keyword %v, got %v", i, want[i], got)
+		}
+	}
+	if _, err := p.Parse(strings.NewReader("repeat() { foo; }"), ""); err == nil {
+		t.Errorf("Expected an error when using a keyword as a function name")
+	}
+	// the words aren't reserved by default
+	f, err = NewParser().Parse(strings.NewReader("repeat() { foo; }; repeat"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Stmts[1].Cmd.(*CallExpr).Keyword {
+		t.Errorf("Unexpected Keyword without ExtraKeywords")
+	}
+	// builtins parsed specially aren't reserved words
+	f, err = NewParser(ExtraKeywords("let", "declare")).Parse(
+		strings.NewReader("let a++; declare -x b"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, stmt := range f.Stmts {
+		if ce, ok := stmt.Cmd.(*CallExpr); !ok || !ce.Keyword {
+			t.Errorf("stmt %d: want a keyword CallExpr, got %T", i, stmt.Cmd)
+		}
+	}
+}
+
+// testExprString formats a test expression in prefix notation, to easily
+// check its structure in tests.
+func testExprString(tb testing.TB, x TestExpr) string {
+	switch x := x.(type) {
+	case nil:
+		return "<nil>"
+	case *Word:
+		var buf bytes.Buffer
+		if err := NewPrinter().Print(&buf, x); err != nil {
+			tb.Fatal(err)
+		}
+		return buf.String()
+	case *BinaryTest:
+		return fmt.Sprintf("(%s %s %s)", x.Op,
+			testExprString(tb, x.X), testExprString(tb, x.Y))
+	case *UnaryTest:
+		return fmt.Sprintf("(%s %s)", x.Op, testExprString(tb, x.X))
+	case *ParenTest:
+		return fmt.Sprintf("(paren %s)", testExprString(tb, x.X))
+	}
+	tb.Fatalf("unexpected test expression: %T", x)
+	return ""
+}
+
+func TestParseTestClauseUnary(t *testing.T) {
+	t.Parallel()
+	ops := []string{
+		"-v", "-R", "-o", "-N", "-e", "-f", "-d", "-s", "-r", "-w", "-x",
+		"-L", "-S", "-p", "-b", "-c", "-g", "-u", "-k", "-t", "-G", "-O",
+		"-z", "-n",
+	}
+	p := NewParser()
+	for _, op := range ops {
+		in := "[[ " + op + " x ]]"
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("unexpected error in %q: %v", in, err)
+		}
+		u, ok := f.Stmts[0].Cmd.(*TestClause).X.(*UnaryTest)
+		if !ok {
+			t.Fatalf("want a *UnaryTest in %q", in)
+		}
+		if got := u.Op.String(); got != op {
+			t.Fatalf("want operator %s in %q, got %s", op, in, got)
+		}
+		if got := u.X.(*Word).Lit(); got != "x" {
+			t.Fatalf("want operand x in %q, got %q", in, got)
+		}
+	}
+	// aliases of other operators
+	for in, want := range map[string]UnTestOperator{
+		"[[ -a x ]]": TsExists,
+		"[[ -h x ]]": TsSmbLink,
+	} {
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("unexpected error in %q: %v", in, err)
+		}
+		if u := f.Stmts[0].Cmd.(*TestClause).X.(*UnaryTest); u.Op != want {
+			t.Fatalf("want operator %s in %q, got %s", want, in, u.Op)
+		}
+	}
+	// without an operand, an unknown operator is just a non-empty string
+	f, err := p.Parse(strings.NewReader("[[ -X ]]"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := f.Stmts[0].Cmd.(*TestClause).X.(*Word); !ok || w.Lit() != "-X" {
+		t.Fatalf("want the word -X, got %#v", f.Stmts[0].Cmd.(*TestClause).X)
+	}
+}
+
+func TestParseTestClauseBinary(t *testing.T) {
+	t.Parallel()
+	ops := []string{
+		"==", "=", "!=", "<", ">", "=~", "-eq", "-ne", "-lt", "-le",
+		"-gt", "-ge", "-nt", "-ot", "-ef",
+	}
+	p := NewParser()
+	for _, op := range ops {
+		in := "[[ a " + op + " b ]]"
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("unexpected error in %q: %v", in, err)
+		}
+		s := f.Stmts[0]
+		if len(s.Redirs) > 0 {
+			t.Fatalf("unexpected redirect in %q", in)
+		}
+		b, ok := s.Cmd.(*TestClause).X.(*BinaryTest)
+		if !ok {
+			t.Fatalf("want a *BinaryTest in %q", in)
+		}
+		if got := b.Op.String(); got != op {
+			t.Fatalf("want operator %s in %q, got %s", op, in, got)
+		}
+		x, y := b.X.(*Word).Lit(), b.Y.(*Word).Lit()
+		if x != "a" || y != "b" {
+			t.Fatalf("want operands a and b in %q, got %q and %q", in, x, y)
+		}
+		if got := b.OpPos.Col(); got != 6 {
+			t.Fatalf("want operator at column 6 in %q, got %d", in, got)
+		}
+	}
+	// "<" and ">" only compare strings within the test clause
+	f, err := p.Parse(strings.NewReader("[[ a<b ]] >c"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.Stmts[0]
+	if b, ok := s.Cmd.(*TestClause).X.(*BinaryTest); !ok || b.Op != TsBefore {
+		t.Fatalf("want a < comparison, got %#v", s.Cmd.(*TestClause).X)
+	}
+	if len(s.Redirs) != 1 || s.Redirs[0].Op != RdrOut || s.Redirs[0].Word.Lit() != "c" {
+		t.Fatalf("want a single >c redirect after the clause, got %d", len(s.Redirs))
+	}
+}
+
+func TestParseTestClauseGroups(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"[[ a && b && c ]]", "(&& (&& a b) c)"},
+		{"[[ a || b || c ]]", "(|| (|| a b) c)"},
+		{"[[ a && b || c ]]", "(|| (&& a b) c)"},
+		{"[[ a || b && c ]]", "(|| a (&& b c))"},
+		{"[[ a || b && c || d ]]", "(|| (|| a (&& b c)) d)"},
+		{"[[ ! a && b ]]", "(&& (! a) b)"},
+		{"[[ ! a == b || c ]]", "(|| (! (== a b)) c)"},
+		{"[[ (a) ]]", "(paren a)"},
+		{"[[ a && (b || c) ]]", "(&& a (paren (|| b c)))"},
+		{"[[ ( -f a || -f b ) && -d c ]]", "(&& (paren (|| (-f a) (-f b))) (-d c))"},
+		{"[[ ! (a || b) ]]", "(! (paren (|| a b)))"},
+		{"[[ ( (a || b) && c) || d ]]", "(|| (paren (&& (paren (|| a b)) c)) d)"},
+		{"[[ (a == b && (c != d || -z e)) ]]", "(paren (&& (== a b) (paren (|| (!= c d) (-z e)))))"},
+		{"[[ ( a &&\n\tb ) ||\n\tc ]]", "(|| (paren (&& a b)) c)"},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := f.Stmts[0].Cmd.(*TestClause).X
+			if got := testExprString(t, x); got != tc.want {
+				t.Fatalf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTestCmds(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"[ -f foo ]", "(-f foo)"},
+		{`test -n "$x"`, `(-n "$x")`},
+		{`[ "$x" = y ]`, `(= "$x" y)`},
+		{"[ $x == y ]", "(== $x y)"},
+		{"[ a \\< b ]", "(< a b)"},
+		{"[ $n -lt 3 ]", "(-lt $n 3)"},
+		{`[ "-z" $x ]`, "(-z $x)"},
+		{"[ -f foo -a ! -d bar -o x ]", "(|| (&& (-f foo) (! (-d bar))) x)"},
+		{"[ \\( a -o b \\) -a c ]", "(&& (paren (|| a b)) c)"},
+		{"[ ! ! x ]", "(! (! x))"},
+		{"[ -a foo ]", "(-e foo)"},
+		// a single argument is a non-empty string test
+		{"[ -n ]", "-n"},
+		{"[ ! ]", "!"},
+		{"[ \\( ]", "\\("},
+		// a binary operator takes precedence
+		{"[ ! = x ]", "(= ! x)"},
+		{"[ -f = x ]", "(= -f x)"},
+		{"[ ]", "<nil>"},
+		{"test", "<nil>"},
+		{"[ -f foo ] >out", "(-f foo)"},
+	}
+	p := NewParser(TestCmds(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tcmd, ok := f.Stmts[0].Cmd.(*TestCmd)
+			if !ok {
+				t.Fatalf("want *TestCmd, got %T", f.Stmts[0].Cmd)
+			}
+			if got := testExprString(t, tcmd.X); got != tc.want {
+				t.Fatalf("want %s, got %s", tc.want, got)
+			}
+			var buf bytes.Buffer
+			if err := NewPrinter().Print(&buf, f); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.in+"\n" {
+				t.Fatalf("want printed %q, got %q", tc.in+"\n", got)
+			}
+		})
+	}
+	// these are left as regular commands
+	for _, in := range []string{
+		"'[' -f foo ]",
+		"a=b test -n x",
+		"echo [ -f foo ]",
+		"[[ -f foo ]]",
+	} {
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.Stmts[0].Cmd.(*TestCmd); ok {
+			t.Errorf("unexpected *TestCmd in %q", in)
+		}
+	}
+	f, err := NewParser().Parse(strings.NewReader("[ -f foo ]"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Stmts[0].Cmd.(*CallExpr); !ok {
+		t.Errorf("want *CallExpr without TestCmds, got %T", f.Stmts[0].Cmd)
+	}
+}
+
+func TestParseTestCmdsErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+		lang     LangVariant
+	}{
+		{in: "[ -f foo", want: `1:1: "[" must be closed by "]"`},
+		{in: "[ -f foo\nbar ]", want: `1:1: "[" must be closed by "]"`},
+		{in: "[ -f foo; bar ]", want: `1:1: "[" must be closed by "]"`},
+		{in: "foo; [ -f foo ]x", want: `1:6: "[" must be closed by "]"`},
+		{in: "[", want: `1:1: "[" must be closed by "]"`},
+		{in: "[ a b ]", want: "1:5: not a valid test operator: b"},
+		{in: "test a b", want: "1:8: not a valid test operator: b"},
+		{in: "[ a $b ]", want: "1:5: test operator words must consist of a single literal"},
+		{in: "[ a = ]", want: "1:5: = must be followed by a word"},
+		{in: "[ a -a ]", want: "1:5: -a must be followed by an expression"},
+		{in: "[ -f x -o ]", want: "1:8: -o must be followed by an expression"},
+		{in: "[ \\( a ]", want: "1:3: ( must be followed by )"},
+		{
+			in:   "[ $x == y ]",
+			want: `1:6: the "==" test operator is a bash/mksh feature`,
+			lang: LangPOSIX,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(TestCmds(true), Variant(tc.lang))
+			_, err := p.Parse(strings.NewReader(tc.in), "")
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("want error %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParseAliasClauses(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"alias", nil},
+		{"alias ll='ls -l'", []string{"ll='ls -l'"}},
+		{"alias ll=ls\\ -l", []string{"ll=ls\\ -l"}},
+		{"alias ..='cd ..' g++=\"g++ -O2\"", []string{"..='cd ..'", `g++="g++ -O2"`}},
+		{"alias foo= bar=$x", []string{"foo=", "bar=$x"}},
+		{"alias -p ll", []string{"(-p)", "ll"}},
+		{"alias \"$x\" =y a'b'=c", []string{`("$x")`, "(=y)", "(a'b'=c)"}},
+		{"alias x=1 >out", []string{"x=1"}},
+	}
+	p := NewParser(AliasClauses(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ac, ok := f.Stmts[0].Cmd.(*AliasClause)
+			if !ok {
+				t.Fatalf("want *AliasClause, got %T", f.Stmts[0].Cmd)
+			}
+			src := []byte(tc.in)
+			var got []string
+			for _, def := range ac.Defs {
+				switch {
+				case def.Name == nil:
+					got = append(got, "("+string(NodeBytes(src, def.Value))+")")
+				case def.Naked:
+					got = append(got, string(NodeBytes(src, def.Name)))
+				case def.Value == nil:
+					got = append(got, string(NodeBytes(src, def.Name))+"=")
+				default:
+					got = append(got, string(NodeBytes(src, def.Name))+"="+
+						string(NodeBytes(src, def.Value)))
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want definitions %q, got %q", tc.want, got)
+			}
+			var buf bytes.Buffer
+			if err := NewPrinter().Print(&buf, f); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.in+"\n" {
+				t.Fatalf("want printed %q, got %q", tc.in+"\n", got)
+			}
+		})
+	}
+	// these are left as regular commands
+	for _, in := range []string{
+		"'alias' ll=ls",
+		"a=b alias ll=ls",
+		"echo alias ll=ls",
+		"alias=ll",
+	} {
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.Stmts[0].Cmd.(*AliasClause); ok {
+			t.Errorf("unexpected *AliasClause in %q", in)
+		}
+	}
+	f, err := p.Parse(strings.NewReader("alias ll=ls; foo=bar"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if as := Assignments(f); len(as) != 1 || as[0].Name.Value != "foo" {
+		t.Errorf("want only the foo variable assignment, got %d", len(as))
+	}
+	f, err = NewParser().Parse(strings.NewReader("alias ll=ls"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Stmts[0].Cmd.(*CallExpr); !ok {
+		t.Errorf("want *CallExpr without AliasClauses, got %T", f.Stmts[0].Cmd)
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	t.Parallel()
+	const dollar = `unquoted "$" is a literal dollar sign`
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"echo $", []string{"1:6: " + dollar}},
+		{"echo $ foo", []string{"1:6: " + dollar}},
+		{"echo a$ b$", []string{"1:7: " + dollar, "1:10: " + dollar}},
+		{"echo $; (echo $)", []string{"1:6: " + dollar, "1:15: " + dollar}},
+		{"echo $ | cat", []string{"1:6: " + dollar}},
+		{"echo \\$", nil},
+		{"echo $x", nil},
+		{"echo '$' \"$\" \"a $\"", nil},
+		{"echo $\"x\" $'y'", nil},
+		{"echo ${x:-$}", nil},
+		{"echo $/", nil},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var got []string
+			p := NewParser(Warnings(func(w Warning) {
+				got = append(got, w.String())
+			}))
+			if _, err := p.Parse(strings.NewReader(tc.in), ""); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want warnings %q, got %q", tc.want, got)
+			}
+		})
+	}
+	var got []string
+	p := NewParser(Warnings(func(w Warning) {
+		got = append(got, w.String())
+	}))
+	if _, err := p.Parse(strings.NewReader("echo $"), "f.sh"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "f.sh:1:6: " + dollar; len(got) != 1 || got[0] != want {
+		t.Fatalf("want warning %q, got %q", want, got)
+	}
+}
+
+func TestParseContinuations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		value string
+		want  []int
+	}{
+		{"echo foo", "foo", nil},
+		{"echo foo\\\nbar", "foobar", []int{3}},
+		{"echo foo\\\r\nbar", "foobar", []int{3}},
+		{"echo a\\\nb\\\nc", "abc", []int{1, 2}},
+		{"echo a\\\n\\\nb", "ab", []int{1, 1}},
+		{"echo foo\\\n", "foo", []int{3}},
+		{"echo a\\ \\\nb", `a\ b`, []int{3}},
+		{"echo ${x:-a\\\nb}", "ab", []int{1}},
+		{"x=a\\\nb", "ab", []int{1}},
+		{"x=\\\nab", "ab", []int{0}},
+		{"alias x=a\\\nb", "ab", []int{1}},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			conts := make(map[*Lit][]int)
+			p := NewParser(Continuations(conts), AliasClauses(true))
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lit *Lit
+			Walk(f, func(node Node) bool {
+				if l, ok := node.(*Lit); ok {
+					switch l.Value {
+					case "echo", "alias", "x":
+					default:
+						lit = l
+					}
+				}
+				return true
+			})
+			if lit == nil || lit.Value != tc.value {
+				t.Fatalf("want literal %q, got %#v", tc.value, lit)
+			}
+			if !reflect.DeepEqual(conts[lit], tc.want) {
+				t.Fatalf("want continuations %v, got %v", tc.want, conts[lit])
+			}
+			if tc.want == nil && len(conts) > 0 {
+				t.Fatalf("want no continuations, got %v", conts)
+			}
+			if tc.want != nil && len(conts) != 1 {
+				t.Fatalf("want continuations for one literal, got %v", conts)
+			}
+		})
+	}
+	f, err := NewParser().Parse(strings.NewReader("echo foo\\\nbar"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lit := f.Stmts[0].Cmd.(*CallExpr).Args[1].Parts[0].(*Lit)
+	if lit.Value != "foobar" {
+		t.Fatalf("want literal %q, got %q", "foobar", lit.Value)
+	}
+}
+
+func TestParseWarnBackquotes(t *testing.T) {
+	t.Parallel()
+	const bquote = "use $(...) instead of backquotes"
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"echo `foo`", []string{"1:6: " + bquote}},
+		{"echo \"`foo`\" `bar`", []string{"1:7: " + bquote, "1:14: " + bquote}},
+		{"echo `foo \\`bar\\``", []string{"1:6: " + bquote, "1:11: " + bquote}},
+		{"echo $(foo) \"$(bar)\"", nil},
+		{"echo '`foo`' \\`", nil},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var got []string
+			warn := Warnings(func(w Warning) {
+				got = append(got, w.String())
+			})
+			if _, err := NewParser(warn).Parse(strings.NewReader(tc.in), ""); err != nil {
+				t.Fatal(err)
+			}
+			if got != nil {
+				t.Fatalf("want no warnings by default, got %q", got)
+			}
+			p := NewParser(warn, WarnBackquotes(true))
+			if _, err := p.Parse(strings.NewReader(tc.in), ""); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want warnings %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePosixFuncDecl(t *testing.T) {
+	t.Parallel()
+	p := NewParser(Variant(LangPOSIX))
+	for _, in := range []string{
+		"foo() { bar; }",
+		"foo() (bar)",
+		"foo ( ) {\n\tbar\n}",
+		"if true; then foo() { bar; }; fi",
+	} {
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("unexpected error in %q: %v", in, err)
+		}
+		var fn *FuncDecl
+		Walk(f, func(node Node) bool {
+			if x, ok := node.(*FuncDecl); ok {
+				fn = x
+			}
+			return true
+		})
+		if fn == nil || fn.RsrvWord || fn.Name.Value != "foo" {
+			t.Fatalf("want a POSIX function declaration in %q, got %#v", in, fn)
+		}
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"function foo { bar; }", `1:1: the "function" keyword is a bash/mksh feature`},
+		{"function foo() { bar; }", `1:1: the "function" keyword is a bash/mksh feature`},
+		{"if true; then function foo { bar; }; fi", `1:15: the "function" keyword is a bash/mksh feature`},
+		{"x &&\n  function foo { bar; }", `2:3: the "function" keyword is a bash/mksh feature`},
+	}
+	for _, tc := range tests {
+		_, err := p.Parse(strings.NewReader(tc.in), "")
+		langErr, ok := err.(LangError)
+		if !ok {
+			t.Fatalf("want a LangError in %q, got %v", tc.in, err)
+		}
+		if got := langErr.Error(); got != tc.want {
+			t.Fatalf("want error %q in %q, got %q", tc.want, tc.in, got)
+		}
+		if _, err := NewParser().Parse(strings.NewReader(tc.in), ""); err != nil {
+			t.Fatalf("unexpected error in %q with Bash: %v", tc.in, err)
+		}
+	}
+}
+
+func TestParseInvalidOptions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		opts []ParserOption
+		want string
+	}{
+		{
+			[]ParserOption{Variant(LangVariant(42))},
+			"invalid parser options: unknown shell language variant: 42",
+		},
+		{
+			[]ParserOption{ExtraKeywords("repeat", "if")},
+			`invalid parser options: extra keyword "if" is already a reserved word`,
+		},
+		{
+			[]ParserOption{Variant(LangPOSIX), ExtraKeywords("[[")},
+			`invalid parser options: extra keyword "[[" is already a reserved word`,
+		},
+		{
+			[]ParserOption{ExtraKeywords("")},
+			`invalid parser options: extra keyword "" must be a single word`,
+		},
+		{
+			[]ParserOption{ExtraKeywords("two words")},
+			`invalid parser options: extra keyword "two words" must be a single word`,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(tc.opts...)
+			_, err := p.Parse(strings.NewReader("foo"), "")
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("want error %q, got %v", tc.want, err)
+			}
+			err = p.Words(strings.NewReader("foo"), func(*Word) bool {
+				t.Fatalf("unexpected word with invalid options")
+				return true
+			})
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("want error %q from Words, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParseKeepBlankLines(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []int
+	}{
+		{"foo\nbar", []int{0, 0}},
+		{"foo\n\n\nbar", []int{0, 2}},
+		{"foo\n  \n\t\nbar; baz", []int{0, 2, 0}},
+		{"\n\nfoo", []int{2}},
+		{"foo\n\n# bar\nbaz\n\n# bar\n\nqux", []int{0, 0, 1}},
+		{"foo <<EOF\nbar\n\nEOF\n\nbaz", []int{0, 1}},
+		{"if foo; then\n\n\tbar\nfi", []int{0, 0, 1}},
+		{"foo &&\n\nbar | \n\n\nbaz", []int{0, 0, 1, 0, 2}},
+	}
+	p := NewParser(KeepBlankLines(true), KeepComments(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			Walk(f, func(node Node) bool {
+				if s, ok := node.(*Stmt); ok {
+					got = append(got, s.BlankLines)
+				}
+				return true
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("blank lines in %q: want %v, got %v",
+					tc.in, tc.want, got)
+			}
+		})
+	}
+	// blank lines aren't recorded by default
+	f, err := NewParser().Parse(strings.NewReader("foo\n\nbar"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Stmts[1].BlankLines; n != 0 {
+		t.Fatalf("Unexpected BlankLines without KeepBlankLines: %d", n)
+	}
+}
+
 var stopAtTests = []struct {
 	in   string
 	stop string
@@ -2185,6 +4062,40 @@ var stopAtTests = []struct {
 	},
 }
 
+func TestParseArithmBracket(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	for _, in := range []string{"1+2", "a * (b - 1)", "x++, y ? 1 : 2"} {
+		brack, err := p.Parse(strings.NewReader("echo $["+in+"]"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paren, err := p.Parse(strings.NewReader("echo $(("+in+"))"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ar *ArithmExp
+		Walk(brack, func(node Node) bool {
+			if x, ok := node.(*ArithmExp); ok {
+				ar = x
+			}
+			return true
+		})
+		if !ar.Bracket {
+			t.Fatalf("$[%s] should be parsed as a bracket ArithmExp", in)
+		}
+		// Other than the flag, both forms result in the same tree.
+		ar.Bracket = false
+		if !Equal(brack, paren) {
+			t.Fatalf("$[%s] and $((%s)) should be equal", in, in)
+		}
+	}
+	_, err := NewParser(Variant(LangPOSIX)).Parse(strings.NewReader("echo $[1+2]"), "")
+	if err != nil {
+		t.Fatalf("$[ should be a literal in POSIX, got: %v", err)
+	}
+}
+
 func TestParseStmtsStopAt(t *testing.T) {
 	t.Parallel()
 	for i, c := range stopAtTests {
@@ -2219,6 +4130,29 @@ func TestValidName(t *testing.T) {
 	}
 }
 
+func TestIsKeyword(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"if", true},
+		{"[[", true},
+		{"{", true},
+		{"esac", true},
+		{"", false},
+		{"foo", false},
+		{"IF", false},
+		{"declare", false},
+		{"let", false},
+	}
+	for _, tc := range tests {
+		if got := IsKeyword(tc.in); got != tc.want {
+			t.Errorf("IsKeyword(%q) got %t, wanted %t", tc.in, got, tc.want)
+		}
+	}
+}
+
 func TestIsIncomplete(t *testing.T) {
 	t.Parallel()
 
@@ -2254,3 +4188,41 @@ func TestIsIncomplete(t *testing.T) {
 		})
 	}
 }
+
+func TestParseReuse(t *testing.T) {
+	t.Parallel()
+	// A parser left in any state by a previous call, including errors
+	// halfway through heredocs or quotes, must parse the next input just
+	// like a fresh parser would.
+	before := append([]string{
+		"foo <<EOF\nbar\nEOF",
+		"foo <<EOF\nbar",
+		"foo <<-EOF && bar <<EOF2\n\tx\n",
+		"echo \"$(foo <<EOF\n",
+		"[[ a =~ (b",
+	}, append(malformedInputs, malformedInputsBash...)...)
+	p := NewParser(KeepComments(true))
+	for i, c := range append(fileTests, fileTestsNoPrint...) {
+		if c.Bash == nil && c.common == nil {
+			continue
+		}
+		for j, in := range c.Strs {
+			want, wantErr := NewParser(KeepComments(true)).Parse(strings.NewReader(in), "")
+			for k, prev := range before {
+				p.Parse(strings.NewReader(prev), "")
+				if k%2 == 0 {
+					p.Document(strings.NewReader(prev))
+				}
+				got, gotErr := p.Parse(strings.NewReader(in), "")
+				if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+					t.Fatalf("%03d-%d: after %q, error mismatch in %q\nwant: %v\ngot:  %v",
+						i, j, prev, in, wantErr, gotErr)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("%03d-%d: after %q, syntax tree mismatch in %q\ndiff:\n%s",
+						i, j, prev, in, strings.Join(pretty.Diff(want, got), "\n"))
+				}
+			}
+		}
+	}
+}
@@ -71,8 +71,9 @@ func KeepPadding(enabled bool) PrinterOption {
 }
 
 // Minify will print programs in a way to save the most bytes possible.
-// For example, indentation and comments are skipped, and extra
-// whitespace is avoided when possible.
+// For example, indentation and comments are skipped, extra whitespace is
+// avoided when possible, and quotes are dropped from arguments which
+// don't need them, such as "foo".
 func Minify(enabled bool) PrinterOption {
 	return func(p *Printer) { p.minify = enabled }
 }
@@ -120,8 +121,33 @@ func (p *Printer) Print(w io.Writer, node Node) error {
 	p.bufWriter.Reset(w)
 	switch x := node.(type) {
 	case *File:
+		if x.Shebang != "" {
+			p.WriteString(x.Shebang)
+			p.line = 1
+			if p.minify && len(x.Stmts) > 0 {
+				// minification doesn't use newlines()
+				p.WriteByte('\n')
+			} else {
+				p.firstLine = false
+				p.wantNewline = true
+			}
+		}
+		p.comments(x.HeaderComments...)
 		p.stmtList(x.Stmts, x.Last)
+		if x.NoTrailingNewline {
+			p.flushHeredocs()
+			p.flushComments()
+			break
+		}
 		p.newline(x.End())
+		blank := x.TrailingBlankLines
+		if len(x.Stmts) == 0 && len(x.Last) == 0 && x.Shebang == "" {
+			// the newline above already stands for a blank line
+			blank--
+		}
+		for i := 0; i < blank; i++ {
+			p.WriteByte('\n')
+		}
 	case *Stmt:
 		p.stmtList([]*Stmt{x}, nil)
 	case Command:
@@ -714,7 +740,7 @@ func (p *Printer) loop(loop Loop) {
 		p.writeLit(x.Name.Value)
 		if x.InPos.IsValid() {
 			p.spacedString(" in", Pos{})
-			p.wordJoin(x.Items)
+			p.wordJoin(x.Items, true)
 		}
 	case *CStyleLoop:
 		p.WriteString("((")
@@ -819,7 +845,10 @@ func (p *Printer) unquotedWord(w *Word) {
 	}
 }
 
-func (p *Printer) wordJoin(ws []*Word) {
+// wordJoin prints a list of words separated by spaces. If args is true, the
+// words are arguments rather than a command name, so that minifying may drop
+// their quotes.
+func (p *Printer) wordJoin(ws []*Word, args bool) {
 	anyNewline := false
 	for _, w := range ws {
 		if pos := w.Pos(); pos.Line() > p.line {
@@ -831,6 +860,11 @@ func (p *Printer) wordJoin(ws []*Word) {
 		} else {
 			p.spacePad(w.Pos())
 		}
+		if s, ok := unquotedLit(w); ok && args && p.minify {
+			p.writeLit(s)
+			p.wantSpace = true
+			continue
+		}
 		p.word(w)
 	}
 	if anyNewline {
@@ -838,6 +872,45 @@ func (p *Printer) wordJoin(ws []*Word) {
 	}
 }
 
+// unquotedLit returns the value of a word made of a single quoted string
+// which means the same without the quotes, such as "foo" or 'foo'.
+func unquotedLit(w *Word) (string, bool) {
+	if len(w.Parts) != 1 {
+		return "", false
+	}
+	var s string
+	switch x := w.Parts[0].(type) {
+	case *SglQuoted:
+		if x.Dollar {
+			return "", false
+		}
+		s = x.Value
+	case *DblQuoted:
+		if x.Dollar || len(x.Parts) != 1 {
+			return "", false
+		}
+		lit, ok := x.Parts[0].(*Lit)
+		if !ok {
+			return "", false
+		}
+		s = lit.Value
+	default:
+		return "", false
+	}
+	if s == "" {
+		return "", false
+	}
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("_-+./,:%@", r):
+		default:
+			return "", false
+		}
+	}
+	return s, true
+}
+
 func (p *Printer) casePatternJoin(pats []*Word) {
 	anyNewline := false
 	for i, w := range pats {
@@ -893,7 +966,11 @@ func (p *Printer) elemJoin(elems []*ArrayElem, last []Comment) {
 
 func (p *Printer) stmt(s *Stmt) {
 	p.wroteSemi = false
-	if s.Negated {
+	if s.Negations > 0 {
+		for i := 0; i < s.Negations; i++ {
+			p.spacedString("!", s.Pos())
+		}
+	} else if s.Negated {
 		p.spacedString("!", s.Pos())
 	}
 	var startRedirs int
@@ -905,19 +982,7 @@ func (p *Printer) stmt(s *Stmt) {
 		if r.OpPos.Line() > p.line {
 			p.bslashNewl()
 		}
-		if p.wantSpace {
-			p.spacePad(r.Pos())
-		}
-		if r.N != nil {
-			p.writeLit(r.N.Value)
-		}
-		p.WriteString(r.Op.String())
-		if p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut) {
-			p.space()
-		} else {
-			p.wantSpace = true
-		}
-		p.word(r.Word)
+		p.redirect(r)
 		if r.Op == Hdoc || r.Op == DashHdoc {
 			p.pendingHdocs = append(p.pendingHdocs, r)
 		}
@@ -946,42 +1011,67 @@ func (p *Printer) stmt(s *Stmt) {
 	p.decLevel()
 }
 
+func (p *Printer) redirect(r *Redirect) {
+	if p.wantSpace {
+		p.spacePad(r.Pos())
+	}
+	if r.N != nil {
+		p.writeLit(r.N.Value)
+	}
+	p.WriteString(r.Op.String())
+	switch {
+	case p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut):
+		p.space()
+	case p.keepPadding:
+		// keep any spacing between the operator and its word, such
+		// as in "2>& 1"
+		p.wantSpace = false
+		p.spacePad(r.Word.Pos())
+	default:
+		p.wantSpace = true
+	}
+	p.word(r.Word)
+}
+
+// callArgs prints the arguments of a simple command, along with any redirects
+// which appear between the command name and its first argument.
+func (p *Printer) callArgs(args []*Word, redirs []*Redirect) (startRedirs int) {
+	if len(args) <= 1 {
+		p.wordJoin(args, false)
+		return 0
+	}
+	p.wordJoin(args[:1], false)
+	for _, r := range redirs {
+		if r.Pos().After(args[1].Pos()) || r.Op == Hdoc || r.Op == DashHdoc {
+			break
+		}
+		p.redirect(r)
+		startRedirs++
+	}
+	p.wordJoin(args[1:], true)
+	return startRedirs
+}
+
 func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 	p.spacePad(cmd.Pos())
 	switch x := cmd.(type) {
 	case *CallExpr:
 		p.assigns(x.Assigns)
-		if len(x.Args) <= 1 {
-			p.wordJoin(x.Args)
-			return 0
-		}
-		p.wordJoin(x.Args[:1])
-		for _, r := range redirs {
-			if r.Pos().After(x.Args[1].Pos()) || r.Op == Hdoc || r.Op == DashHdoc {
-				break
-			}
-			if p.wantSpace {
-				p.spacePad(r.Pos())
-			}
-			if r.N != nil {
-				p.writeLit(r.N.Value)
-			}
-			p.WriteString(r.Op.String())
-			if p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut) {
-				p.space()
-			} else {
-				p.wantSpace = true
-			}
-			p.word(r.Word)
-			startRedirs++
-		}
-		p.wordJoin(x.Args[1:])
+		startRedirs = p.callArgs(x.Args, redirs)
+	case *TestCmd:
+		startRedirs = p.callArgs(x.Args, redirs)
 	case *Block:
 		p.WriteByte('{')
 		p.wantSpace = true
 		// Forbid "foo()\n{ bar; }"
 		p.wantNewline = p.wantNewline || p.funcNextLine
 		p.nestedStmts(x.Stmts, x.Last, x.Rbrace)
+		if len(x.Stmts) == 0 && !p.wantNewline && x.Rbrace.Line() <= p.line {
+			// "{ }" is an empty block, but "{; }" doesn't parse
+			p.WriteString(" }")
+			p.wantSpace = true
+			break
+		}
 		p.semiRsrv("}", x.Rbrace)
 	case *IfClause:
 		p.ifClause(x, false)
@@ -1084,6 +1174,10 @@ func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 				p.comments(c)
 			}
 			p.newlines(ci.Pos())
+			if ci.Lparen.IsValid() {
+				p.spacePad(ci.Lparen)
+				p.WriteByte('(')
+			}
 			p.casePatternJoin(ci.Patterns)
 			p.WriteByte(')')
 			p.wantSpace = !p.minify
@@ -1127,6 +1221,9 @@ func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 	case *DeclClause:
 		p.spacedString(x.Variant.Value, x.Pos())
 		p.assigns(x.Args)
+	case *AliasClause:
+		p.spacedString(x.Alias.Value, x.Pos())
+		p.assigns(x.Defs)
 	case *TimeClause:
 		p.spacedString("time", x.Pos())
 		if x.PosixFormat {
@@ -75,7 +75,17 @@ var printTests = []printCase{
 	samePrint("#"),
 	samePrint("#c1\\\n#c2"),
 	samePrint("#\\\n#"),
+	samePrint("#!/bin/sh\nfoo"),
+	samePrint("#!/bin/sh\n\nfoo"),
+	samePrint("#!/bin/sh\n# foo\nbar"),
+	samePrint("#!/bin/sh"),
+	{"#!/bin/sh\n\n\nfoo", "#!/bin/sh\n\nfoo"},
 	samePrint("{\n\t# foo \\\n}"),
+	samePrint("{ }"),
+	samePrint("{\n}"),
+	samePrint("foo() { }"),
+	samePrint("{ } && { }"),
+	{"{   }", "{ }"},
 	samePrint("foo\\\\\nbar"),
 	samePrint("a=b # inline\nbar"),
 	samePrint("a=$(b) # inline"),
@@ -229,6 +239,11 @@ var printTests = []printCase{
 	samePrint("#before\nfoo && bar"),
 	samePrint("foo | bar # inline"),
 	samePrint("foo && bar # inline"),
+	samePrint("! foo"),
+	samePrint("! ! foo"),
+	samePrint("! ! ! foo | bar"),
+	samePrint("foo && ! ! bar"),
+	{"!\t! foo", "! ! foo"},
 	samePrint("for a in 1 2; do\n\n\tbar\ndone"),
 	{
 		"a \\\n\t&& b",
@@ -377,6 +392,11 @@ var printTests = []printCase{
 	samePrint("case a in b) [[ x =~ y ]] ;; esac"),
 	samePrint("case a in b) [[ a =~ b$ || c =~ d$ ]] ;; esac"),
 	samePrint("case a in b) [[ a =~ (b) ]] ;; esac"),
+	samePrint("case x in a) b ;; esac"),
+	samePrint("case x in (a) b ;; esac"),
+	samePrint("case x in\n(a | b) c ;;\nd) e ;;\nesac"),
+	samePrint("case x in\n(a | \\\n\tb) c ;;\nesac"),
+	{"case x in ( a) b;; esac", "case x in (a) b ;; esac"},
 	samePrint("[[ (a =~ b$) ]]"),
 	{
 		"a=(\nb\nc\n) b=c",
@@ -408,6 +428,7 @@ var printTests = []printCase{
 	samePrint("\"foo\\\n$(bar)\""),
 	samePrint("\"foo\\\nbar\""),
 	samePrint("((foo++)) || bar"),
+	{"echo $[1+2] \"$[a * (b - 1)]\"", "echo $((1 + 2)) \"$((a * (b - 1)))\""},
 	{
 		"a=b \\\nc=d \\\nfoo",
 		"a=b \\\n\tc=d \\\n\tfoo",
@@ -804,6 +825,9 @@ func TestPrintKeepPadding(t *testing.T) {
 		samePrint("a=b  c=d   bar"),
 		samePrint("echo foo    >bar"),
 		samePrint("echo foo    2>bar"),
+		samePrint("echo foo 2>&1"),
+		samePrint("echo foo 2>& 1"),
+		samePrint("echo foo >  bar 2>&   1"),
 		samePrint("{ foo;  }"),
 		samePrint("a()   { foo; }"),
 		samePrint("a   && b"),
@@ -830,6 +854,43 @@ func TestPrintKeepPadding(t *testing.T) {
 	}
 }
 
+func TestPrintKeepBlankLines(t *testing.T) {
+	t.Parallel()
+	tests := []string{
+		"",
+		"\n",
+		"\n\n",
+		"foo",
+		"foo\n",
+		"foo\n\n\n",
+		"foo\n# bar",
+		"foo\n# bar\n\n",
+		"foo # bar",
+		"cat <<EOF\nbar\nEOF",
+		"cat <<EOF\nbar\nEOF\n\n",
+		"#!/bin/sh",
+		"#!/bin/sh\n",
+		"#!/bin/sh\n\nfoo\n\n",
+	}
+	parser := NewParser(KeepComments(true), KeepBlankLines(true))
+	printer := NewPrinter()
+	for i, in := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			prog, err := parser.Parse(strings.NewReader(in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := strPrint(printer, prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != in {
+				t.Fatalf("Print mismatch:\nwant:\n%q\ngot:\n%q", in, got)
+			}
+		})
+	}
+}
+
 func TestPrintMinify(t *testing.T) {
 	t.Parallel()
 	tests := [...]printCase{
@@ -851,6 +912,15 @@ func TestPrintMinify(t *testing.T) {
 			"foo&",
 		},
 		samePrint("foo >bar 2>baz <etc"),
+		samePrint("{ }"),
+		{
+			"#!/bin/sh\n\n# foo\nbar",
+			"#!/bin/sh\nbar",
+		},
+		{
+			"#!/bin/sh\n# foo",
+			"#!/bin/sh",
+		},
 		{
 			"{\n\tfoo\n}",
 			"{\nfoo\n}",
@@ -895,6 +965,23 @@ func TestPrintMinify(t *testing.T) {
 			"${0/${a}\\\n}",
 			"${0/$a/}",
 		},
+		{
+			`echo "foo" 'bar' "a b" "" "$x" $'y' "a=b" "-n" "*"`,
+			`echo foo bar "a b" "" "$x" $'y' "a=b" -n "*"`,
+		},
+		{
+			`"echo" "x" >"out"`,
+			`"echo" x >"out"`,
+		},
+		{
+			`echo $"hello" "hello"`,
+			`echo $"hello" hello`,
+		},
+		samePrint("x='' y=\"\" z=''\"\"\necho '' \"\""),
+		{
+			`for i in "a" 'b.c'; do "if" "fi"; done`,
+			`for i in a b.c;do "if" fi;done`,
+		},
 	}
 	parser := NewParser(KeepComments(true))
 	printer := NewPrinter(Minify(true))
@@ -928,10 +1015,19 @@ func TestPrintMinifyNotBroken(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			_, err = parser.Parse(strings.NewReader(got), "")
+			prog, err = parser.Parse(strings.NewReader(got), "")
 			if err != nil {
 				t.Fatalf("minified program was broken: %v\n%s", err, got)
 			}
+			got2, err := strPrint(printer, prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A trailing backslash turns into a line continuation
+			// once printed; see FuzzParsePrint.
+			if got2 != got && !strings.HasSuffix(in, "\\") {
+				t.Fatalf("minifying is not stable:\n%s\nversus:\n%s", got, got2)
+			}
 		})
 	}
 	for i, tc := range printTests {
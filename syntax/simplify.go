@@ -62,6 +62,13 @@ func (s *simplifier) visit(node Node) bool {
 		x.Stmts = s.inlineSubshell(x.Stmts)
 	case *Word:
 		x.Parts = s.simplifyWord(x.Parts)
+	case *TestCmd:
+		// Quotes and parentheses matter in test commands, as the
+		// operands are regular arguments. Only simplify the words.
+		for _, w := range x.Args {
+			Walk(w, s.visit)
+		}
+		return false
 	case *TestClause:
 		x.X = s.removeParensTest(x.X)
 		x.X = s.removeNegateTest(x.X)
@@ -171,8 +178,8 @@ func (s *simplifier) inlineSimpleParams(x ArithmExpr) ArithmExpr {
 func (s *simplifier) inlineSubshell(stmts []*Stmt) []*Stmt {
 	for len(stmts) == 1 {
 		st := stmts[0]
-		if st.Negated || st.Background || st.Coprocess ||
-			len(st.Redirs) > 0 {
+		if st.Negated || st.Negations > 0 || st.Background ||
+			st.Coprocess || len(st.Redirs) > 0 {
 			break
 		}
 		sub, _ := st.Cmd.(*Subshell)
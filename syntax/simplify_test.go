@@ -33,6 +33,7 @@ var simplifyTests = [...]simplifyTest{
 	noSimple("a[$b]=2"),
 	noSimple("${a[$b]}"),
 	noSimple("(($3 == $#))"),
+	noSimple(`x='' y="" z=''""`),
 
 	// test exprs
 	{`[[ "$foo" == "bar" ]]`, `[[ $foo == "bar" ]]`},
@@ -35,6 +35,9 @@ func Walk(node Node, f func(Node) bool) {
 
 	switch x := node.(type) {
 	case *File:
+		for _, c := range x.HeaderComments {
+			Walk(&c, f)
+		}
 		walkStmts(x.Stmts, x.Last, f)
 	case *Comment:
 	case *Stmt:
@@ -178,6 +181,13 @@ func Walk(node Node, f func(Node) bool) {
 		walkStmts(x.Stmts, x.Last, f)
 	case *TestClause:
 		Walk(x.X, f)
+	case *TestCmd:
+		walkWords(x.Args, f)
+	case *AliasClause:
+		Walk(x.Alias, f)
+		for _, a := range x.Defs {
+			Walk(a, f)
+		}
 	case *DeclClause:
 		for _, a := range x.Args {
 			Walk(a, f)
@@ -227,8 +237,574 @@ func Walk(node Node, f func(Node) bool) {
 	f(nil)
 }
 
+// Cursor describes a node encountered during Apply. Information about the node
+// and its parent is available from the Node and Parent methods.
+type Cursor struct {
+	parent Node
+	node   Node
+	set    func(Node)
+}
+
+// Node returns the current node.
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the current node, or nil if the current node is
+// the root given to Apply.
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Replace replaces the current node with n. The node must be non-nil, and of
+// a type which can take the place of the current node in its parent, such as
+// any Command for the Cmd field of a Stmt; otherwise, Replace panics.
+//
+// The replacement node is not walked by Apply, though its children are if
+// Replace is called from the pre function.
+func (c *Cursor) Replace(n Node) {
+	c.set(n)
+	c.node = n
+}
+
+// abortApply is used to stop Apply early, when post returns false.
+var abortApply = new(int)
+
+// Apply traverses a syntax tree recursively, starting with root, and calling
+// pre and post for each node with a Cursor describing it. The nodes are
+// visited in the same order as with Walk, except for the comments following a
+// Stmt, CaseItem, or ArrayElem: Walk only visits the first of them once it is
+// done with the node, while Apply visits all of them as the node's last
+// children, before calling post. Either function may be nil.
+//
+// If pre is not nil, it is called for each node before the node's children
+// are traversed. If it returns false, the children and post are skipped.
+//
+// If post is not nil, it is called for each node after its children are
+// traversed. If it returns false, the traversal is stopped, and Apply
+// returns immediately.
+//
+// Both functions may replace the current node via Cursor.Replace. Apply
+// returns the root of the modified tree, which is different from root if
+// the root itself was replaced.
+func Apply(root Node, pre, post func(*Cursor) bool) (result Node) {
+	a := &applier{pre: pre, post: post}
+	defer func() {
+		if r := recover(); r != nil && r != abortApply {
+			panic(r)
+		}
+		result = root
+	}()
+	a.apply(nil, root, func(n Node) { root = n })
+	return
+}
+
+type applier struct {
+	pre, post func(*Cursor) bool
+	cursor    Cursor
+}
+
+func (a *applier) apply(parent, node Node, set func(Node)) {
+	saved := a.cursor
+	a.cursor = Cursor{parent: parent, node: node, set: set}
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	switch x := a.cursor.node.(type) {
+	case *File:
+		a.comments(x, x.HeaderComments)
+		a.stmts(x, x.Stmts, x.Last)
+	case *Comment:
+	case *Stmt:
+		split := len(x.Comments)
+		for i, c := range x.Comments {
+			if !x.End().After(c.Pos()) {
+				split = i
+				break
+			}
+		}
+		a.comments(x, x.Comments[:split])
+		if x.Cmd != nil {
+			a.apply(x, x.Cmd, func(n Node) { x.Cmd = n.(Command) })
+		}
+		for i := range x.Redirs {
+			i := i
+			a.apply(x, x.Redirs[i], func(n Node) { x.Redirs[i] = n.(*Redirect) })
+		}
+		a.comments(x, x.Comments[split:])
+	case *Assign:
+		if x.Name != nil {
+			a.apply(x, x.Name, func(n Node) { x.Name = n.(*Lit) })
+		}
+		if x.Value != nil {
+			a.apply(x, x.Value, func(n Node) { x.Value = n.(*Word) })
+		}
+		if x.Index != nil {
+			a.apply(x, x.Index, func(n Node) { x.Index = n.(ArithmExpr) })
+		}
+		if x.Array != nil {
+			a.apply(x, x.Array, func(n Node) { x.Array = n.(*ArrayExpr) })
+		}
+	case *Redirect:
+		if x.N != nil {
+			a.apply(x, x.N, func(n Node) { x.N = n.(*Lit) })
+		}
+		a.apply(x, x.Word, func(n Node) { x.Word = n.(*Word) })
+		if x.Hdoc != nil {
+			a.apply(x, x.Hdoc, func(n Node) { x.Hdoc = n.(*Word) })
+		}
+	case *CallExpr:
+		for i := range x.Assigns {
+			i := i
+			a.apply(x, x.Assigns[i], func(n Node) { x.Assigns[i] = n.(*Assign) })
+		}
+		a.words(x, x.Args)
+	case *Subshell:
+		a.stmts(x, x.Stmts, x.Last)
+	case *Block:
+		a.stmts(x, x.Stmts, x.Last)
+	case *IfClause:
+		a.stmts(x, x.Cond, x.CondLast)
+		a.stmts(x, x.Then, x.ThenLast)
+		if x.Else != nil {
+			a.apply(x, x.Else, func(n Node) { x.Else = n.(*IfClause) })
+		}
+	case *WhileClause:
+		a.stmts(x, x.Cond, x.CondLast)
+		a.stmts(x, x.Do, x.DoLast)
+	case *ForClause:
+		a.apply(x, x.Loop, func(n Node) { x.Loop = n.(Loop) })
+		a.stmts(x, x.Do, x.DoLast)
+	case *WordIter:
+		a.apply(x, x.Name, func(n Node) { x.Name = n.(*Lit) })
+		a.words(x, x.Items)
+	case *CStyleLoop:
+		if x.Init != nil {
+			a.apply(x, x.Init, func(n Node) { x.Init = n.(ArithmExpr) })
+		}
+		if x.Cond != nil {
+			a.apply(x, x.Cond, func(n Node) { x.Cond = n.(ArithmExpr) })
+		}
+		if x.Post != nil {
+			a.apply(x, x.Post, func(n Node) { x.Post = n.(ArithmExpr) })
+		}
+	case *BinaryCmd:
+		a.apply(x, x.X, func(n Node) { x.X = n.(*Stmt) })
+		a.apply(x, x.Y, func(n Node) { x.Y = n.(*Stmt) })
+	case *FuncDecl:
+		a.apply(x, x.Name, func(n Node) { x.Name = n.(*Lit) })
+		a.apply(x, x.Body, func(n Node) { x.Body = n.(*Stmt) })
+	case *Word:
+		a.wordParts(x, x.Parts)
+	case *Lit:
+	case *SglQuoted:
+	case *DblQuoted:
+		a.wordParts(x, x.Parts)
+	case *CmdSubst:
+		a.stmts(x, x.Stmts, x.Last)
+	case *ParamExp:
+		a.apply(x, x.Param, func(n Node) { x.Param = n.(*Lit) })
+		if x.Index != nil {
+			a.apply(x, x.Index, func(n Node) { x.Index = n.(ArithmExpr) })
+		}
+		if x.Repl != nil {
+			repl := x.Repl
+			if repl.Orig != nil {
+				a.apply(x, repl.Orig, func(n Node) { repl.Orig = n.(*Word) })
+			}
+			if repl.With != nil {
+				a.apply(x, repl.With, func(n Node) { repl.With = n.(*Word) })
+			}
+		}
+		if x.Exp != nil && x.Exp.Word != nil {
+			exp := x.Exp
+			a.apply(x, exp.Word, func(n Node) { exp.Word = n.(*Word) })
+		}
+	case *ArithmExp:
+		a.apply(x, x.X, func(n Node) { x.X = n.(ArithmExpr) })
+	case *ArithmCmd:
+		a.apply(x, x.X, func(n Node) { x.X = n.(ArithmExpr) })
+	case *BinaryArithm:
+		a.apply(x, x.X, func(n Node) { x.X = n.(ArithmExpr) })
+		a.apply(x, x.Y, func(n Node) { x.Y = n.(ArithmExpr) })
+	case *BinaryTest:
+		a.apply(x, x.X, func(n Node) { x.X = n.(TestExpr) })
+		a.apply(x, x.Y, func(n Node) { x.Y = n.(TestExpr) })
+	case *UnaryArithm:
+		a.apply(x, x.X, func(n Node) { x.X = n.(ArithmExpr) })
+	case *UnaryTest:
+		a.apply(x, x.X, func(n Node) { x.X = n.(TestExpr) })
+	case *ParenArithm:
+		a.apply(x, x.X, func(n Node) { x.X = n.(ArithmExpr) })
+	case *ParenTest:
+		a.apply(x, x.X, func(n Node) { x.X = n.(TestExpr) })
+	case *CaseClause:
+		a.apply(x, x.Word, func(n Node) { x.Word = n.(*Word) })
+		for i := range x.Items {
+			i := i
+			a.apply(x, x.Items[i], func(n Node) { x.Items[i] = n.(*CaseItem) })
+		}
+		a.comments(x, x.Last)
+	case *CaseItem:
+		split := len(x.Comments)
+		for i, c := range x.Comments {
+			if c.Pos().After(x.Pos()) {
+				split = i
+				break
+			}
+		}
+		a.comments(x, x.Comments[:split])
+		a.words(x, x.Patterns)
+		a.stmts(x, x.Stmts, x.Last)
+		a.comments(x, x.Comments[split:])
+	case *TestClause:
+		a.apply(x, x.X, func(n Node) { x.X = n.(TestExpr) })
+	case *TestCmd:
+		for i := range x.Args {
+			i := i
+			a.apply(x, x.Args[i], func(n Node) {
+				w := n.(*Word)
+				x.X = replaceTestWord(x.X, x.Args[i], w)
+				x.Args[i] = w
+			})
+		}
+	case *AliasClause:
+		a.apply(x, x.Alias, func(n Node) { x.Alias = n.(*Lit) })
+		for i := range x.Defs {
+			i := i
+			a.apply(x, x.Defs[i], func(n Node) { x.Defs[i] = n.(*Assign) })
+		}
+	case *DeclClause:
+		for i := range x.Args {
+			i := i
+			a.apply(x, x.Args[i], func(n Node) { x.Args[i] = n.(*Assign) })
+		}
+	case *ArrayExpr:
+		for i := range x.Elems {
+			i := i
+			a.apply(x, x.Elems[i], func(n Node) { x.Elems[i] = n.(*ArrayElem) })
+		}
+		a.comments(x, x.Last)
+	case *ArrayElem:
+		split := len(x.Comments)
+		for i, c := range x.Comments {
+			if c.Pos().After(x.Pos()) {
+				split = i
+				break
+			}
+		}
+		a.comments(x, x.Comments[:split])
+		if x.Index != nil {
+			a.apply(x, x.Index, func(n Node) { x.Index = n.(ArithmExpr) })
+		}
+		if x.Value != nil {
+			a.apply(x, x.Value, func(n Node) { x.Value = n.(*Word) })
+		}
+		a.comments(x, x.Comments[split:])
+	case *ExtGlob:
+		a.apply(x, x.Pattern, func(n Node) { x.Pattern = n.(*Lit) })
+	case *ProcSubst:
+		a.stmts(x, x.Stmts, x.Last)
+	case *TimeClause:
+		if x.Stmt != nil {
+			a.apply(x, x.Stmt, func(n Node) { x.Stmt = n.(*Stmt) })
+		}
+	case *CoprocClause:
+		if x.Name != nil {
+			a.apply(x, x.Name, func(n Node) { x.Name = n.(*Word) })
+		}
+		a.apply(x, x.Stmt, func(n Node) { x.Stmt = n.(*Stmt) })
+	case *LetClause:
+		for i := range x.Exprs {
+			i := i
+			a.apply(x, x.Exprs[i], func(n Node) { x.Exprs[i] = n.(ArithmExpr) })
+		}
+	default:
+		panic(fmt.Sprintf("syntax.Apply: unexpected node type %T", x))
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abortApply)
+	}
+	a.cursor = saved
+}
+
+func (a *applier) stmts(parent Node, stmts []*Stmt, last []Comment) {
+	for i := range stmts {
+		i := i
+		a.apply(parent, stmts[i], func(n Node) { stmts[i] = n.(*Stmt) })
+	}
+	a.comments(parent, last)
+}
+
+func (a *applier) comments(parent Node, comments []Comment) {
+	for i := range comments {
+		i := i
+		a.apply(parent, &comments[i], func(n Node) { comments[i] = *n.(*Comment) })
+	}
+}
+
+// replaceTestWord replaces old with new in the operands of a TestCmd's
+// expression, which are shared with its arguments.
+func replaceTestWord(x TestExpr, old, new *Word) TestExpr {
+	switch y := x.(type) {
+	case *Word:
+		if y == old {
+			return new
+		}
+	case *BinaryTest:
+		y.X = replaceTestWord(y.X, old, new)
+		y.Y = replaceTestWord(y.Y, old, new)
+	case *UnaryTest:
+		y.X = replaceTestWord(y.X, old, new)
+	case *ParenTest:
+		y.X = replaceTestWord(y.X, old, new)
+	}
+	return x
+}
+
+func (a *applier) words(parent Node, words []*Word) {
+	for i := range words {
+		i := i
+		a.apply(parent, words[i], func(n Node) { words[i] = n.(*Word) })
+	}
+}
+
+func (a *applier) wordParts(parent Node, parts []WordPart) {
+	for i := range parts {
+		i := i
+		a.apply(parent, parts[i], func(n Node) { parts[i] = n.(WordPart) })
+	}
+}
+
+// Functions returns all the function declarations found in the provided
+// syntax tree, keyed by name. This includes functions declared within other
+// commands, such as blocks, subshells, or the bodies of other functions.
+//
+// If a name is declared more than once, the last declaration in the source
+// wins, just like when the declarations are run in order.
+func Functions(node Node) map[string]*FuncDecl {
+	funcs := make(map[string]*FuncDecl)
+	Walk(node, func(node Node) bool {
+		if fd, ok := node.(*FuncDecl); ok {
+			funcs[fd.Name.Value] = fd
+		}
+		return true
+	})
+	return funcs
+}
+
+// Assignment is a variable assignment found by Assignments.
+type Assignment struct {
+	*Assign
+
+	// Decl is the declaration clause containing the assignment, if any.
+	Decl *DeclClause
+
+	// Local is true if the variable is local to the enclosing function,
+	// such as with "local foo=bar", or with "declare foo=bar" inside a
+	// function body and without the -g option.
+	Local bool
+}
+
+// Assignments returns all the variable assignments found in the provided
+// syntax tree, in the order they appear in the source. This includes
+// assignments prefixing a command as in "foo=bar cmd", array assignments,
+// and the ones in declaration clauses like "declare" or "local".
+//
+// Naked assignments such as "local foo" are included, as they declare a
+// variable, but the options in declaration clauses like "-a" are not.
+// Whether an assignment appends to a variable is recorded in Assign.Append.
+// The definitions in an *AliasClause are not variables, so they are skipped.
+func Assignments(node Node) []Assignment {
+	var list []Assignment
+	var stack []Node
+	Walk(node, func(node Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		var parent Node
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		if _, ok := parent.(*AliasClause); ok {
+			// alias definitions aren't variables
+		} else if as, ok := node.(*Assign); ok && as.Name != nil {
+			a := Assignment{Assign: as}
+			a.Decl, _ = parent.(*DeclClause)
+			if a.Decl != nil {
+				a.Local = declLocal(a.Decl, stack)
+			}
+			list = append(list, a)
+		}
+		stack = append(stack, node)
+		return true
+	})
+	return list
+}
+
+// Enclosing returns the path of nodes from the given root down to the innermost
+// node containing a position, from its first character up to but excluding its
+// end. The root is always the first element.
+//
+// A position in the whitespace between nodes results in a path ending with the
+// nearest node around it, such as an *IfClause for the spaces between "then"
+// and its first statement. Positions within here-document bodies are found too,
+// even though the statement with the redirect does not cover them.
+func Enclosing(root Node, pos Pos) []Node {
+	path := []Node{root}
+	var stack []Node
+	Walk(root, func(node Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, node)
+		// Nodes are visited in source order, so the last one containing
+		// the position is the innermost.
+		if node.Pos().IsValid() && node.End().IsValid() &&
+			!node.Pos().After(pos) && node.End().After(pos) {
+			path = append(path[:0], stack...)
+		}
+		return true
+	})
+	return path
+}
+
+// declLocal reports whether the variables in a declaration clause are local,
+// given the stack of its ancestor nodes.
+func declLocal(decl *DeclClause, stack []Node) bool {
+	switch decl.Variant.Value {
+	case "local":
+		return true
+	case "declare", "typeset":
+	default:
+		return false
+	}
+	if decl.HasOpt('g') {
+		return false
+	}
+	for _, node := range stack {
+		if _, ok := node.(*FuncDecl); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether two syntax trees are equal, comparing all of their
+// nodes and fields except for positions. This can be useful to check that
+// reformatting a program, which moves its nodes around, did not change it.
+//
+// Nil and empty slices are considered equal.
+func Equal(a, b Node) bool {
+	return equalValue(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+var posType = reflect.TypeOf(Pos{})
+
+func equalValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalValue(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type() == posType {
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !equalValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
+
+// Copy returns a deep copy of a syntax tree. None of its nodes, slices, or
+// pointers are shared with the original, so either can be modified without
+// affecting the other. Nodes shared within the original, like the words in a
+// TestCmd's Args and X, are shared within the copy too.
+func Copy(node Node) Node {
+	if node == nil {
+		return nil
+	}
+	c := copier{ptrs: make(map[interface{}]reflect.Value)}
+	return c.copy(reflect.ValueOf(node)).Interface().(Node)
+}
+
+type copier struct {
+	ptrs map[interface{}]reflect.Value // copies of the pointers seen so far
+}
+
+func (c *copier) copy(x reflect.Value) reflect.Value {
+	switch x.Kind() {
+	case reflect.Interface:
+		if x.IsNil() {
+			return x
+		}
+		v := reflect.New(x.Type()).Elem()
+		v.Set(c.copy(x.Elem()))
+		return v
+	case reflect.Ptr:
+		if x.IsNil() {
+			return x
+		}
+		if v, ok := c.ptrs[x.Interface()]; ok {
+			return v
+		}
+		v := reflect.New(x.Type().Elem())
+		c.ptrs[x.Interface()] = v
+		v.Elem().Set(c.copy(x.Elem()))
+		return v
+	case reflect.Slice:
+		if x.IsNil() {
+			return x
+		}
+		v := reflect.MakeSlice(x.Type(), x.Len(), x.Len())
+		for i := 0; i < x.Len(); i++ {
+			v.Index(i).Set(c.copy(x.Index(i)))
+		}
+		return v
+	case reflect.Struct:
+		// Copy the whole struct first, as Pos has unexported fields.
+		v := reflect.New(x.Type()).Elem()
+		v.Set(x)
+		for i := 0; i < x.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				f.Set(c.copy(x.Field(i)))
+			}
+		}
+		return v
+	default:
+		return x
+	}
+}
+
 // DebugPrint prints the provided syntax tree, spanning multiple lines and with
 // indentation. Can be useful to investigate the content of a syntax tree.
+//
+// Each node is printed with its type and all of its fields, including
+// positions as line:col and operators along with their source form.
 func DebugPrint(w io.Writer, node Node) error {
 	p := debugPrinter{out: w}
 	p.print(reflect.ValueOf(node))
@@ -305,6 +881,11 @@ func (p *debugPrinter) print(x reflect.Value) {
 		}
 		p.printf("}")
 	default:
+		// Operators are numeric, so also show what they stand for.
+		if s, ok := x.Interface().(fmt.Stringer); ok && !x.IsZero() {
+			p.printf("%#v (%s)", x.Interface(), s)
+			return
+		}
 		p.printf("%#v", x.Interface())
 	}
 }
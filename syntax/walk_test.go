@@ -4,8 +4,10 @@
 package syntax
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -122,3 +124,593 @@ func TestWalkUnexpectedType(t *testing.T) {
 		return true
 	})
 }
+
+func TestApplyOrder(t *testing.T) {
+	t.Parallel()
+	// Apply visits the same nodes as Walk, in the same order.
+	parser := NewParser(KeepComments(true))
+	for i, c := range fileTests {
+		in := c.Strs[0]
+		f, err := parser.Parse(strings.NewReader(in), "")
+		if err != nil {
+			continue
+		}
+		var walked, applied []Node
+		Walk(f, func(node Node) bool {
+			if node != nil {
+				walked = append(walked, node)
+			}
+			return true
+		})
+		var posts int
+		Apply(f, func(c *Cursor) bool {
+			applied = append(applied, c.Node())
+			return true
+		}, func(c *Cursor) bool {
+			posts++
+			return true
+		})
+		if len(walked) != len(applied) || posts != len(applied) {
+			t.Fatalf("%03d: walked %d nodes, but applied %d with %d posts in %q",
+				i, len(walked), len(applied), posts, in)
+		}
+		for j := range walked {
+			// comments are copied by Walk
+			if _, ok := walked[j].(*Comment); ok {
+				if *walked[j].(*Comment) != *applied[j].(*Comment) {
+					t.Fatalf("%03d: comment %d differs in %q", i, j, in)
+				}
+			} else if walked[j] != applied[j] {
+				t.Fatalf("%03d: node %d is %T with Walk, but %T with Apply in %q",
+					i, j, walked[j], applied[j], in)
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	parse := func(in string) *File {
+		f, err := NewParser().Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	printNode := func(node Node) string {
+		var buf bytes.Buffer
+		if err := NewPrinter().Print(&buf, node); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// replace the first word of each call, keeping track of the parents
+	f := parse("echo a; if true; then echo $(echo b); fi")
+	var parents []string
+	got := Apply(f, nil, func(c *Cursor) bool {
+		if ce, ok := c.Node().(*CallExpr); ok && ce.Args[0].Lit() == "echo" {
+			parents = append(parents, fmt.Sprintf("%T", c.Parent()))
+			name := &Lit{ValuePos: ce.Pos(), ValueEnd: ce.Args[0].End(), Value: "print"}
+			c.Replace(&CallExpr{Args: append([]*Word{
+				{Parts: []WordPart{name}},
+			}, ce.Args[1:]...)})
+		}
+		return true
+	})
+	if got != f {
+		t.Fatalf("Apply returned a different root")
+	}
+	want := "print a\nif true; then print $(print b); fi\n"
+	if s := printNode(f); s != want {
+		t.Fatalf("want %q, got %q", want, s)
+	}
+	if want := "[*syntax.Stmt *syntax.Stmt *syntax.Stmt]"; fmt.Sprint(parents) != want {
+		t.Fatalf("want parents %s, got %s", want, parents)
+	}
+
+	// pre returning false skips the children and post
+	var seen []string
+	Apply(parse("foo $(bar) baz"), func(c *Cursor) bool {
+		if lit, ok := c.Node().(*Lit); ok {
+			seen = append(seen, lit.Value)
+		}
+		_, ok := c.Node().(*CmdSubst)
+		return !ok
+	}, func(c *Cursor) bool {
+		if _, ok := c.Node().(*CmdSubst); ok {
+			t.Errorf("post called for a skipped node")
+		}
+		return true
+	})
+	if want := "[foo baz]"; fmt.Sprint(seen) != want {
+		t.Fatalf("want %s, got %s", want, seen)
+	}
+
+	// post returning false stops the traversal
+	seen = nil
+	Apply(parse("foo; bar; baz"), nil, func(c *Cursor) bool {
+		if lit, ok := c.Node().(*Lit); ok {
+			seen = append(seen, lit.Value)
+			return lit.Value != "bar"
+		}
+		return true
+	})
+	if want := "[foo bar]"; fmt.Sprint(seen) != want {
+		t.Fatalf("want %s, got %s", want, seen)
+	}
+
+	// replacing in pre walks the children of the new node
+	seen = nil
+	w := parse("foo").Stmts[0].Cmd.(*CallExpr).Args[0]
+	Apply(w, func(c *Cursor) bool {
+		switch x := c.Node().(type) {
+		case *Word:
+		case *Lit:
+			seen = append(seen, x.Value)
+		default:
+			t.Errorf("unexpected node: %T", x)
+		}
+		if _, ok := c.Node().(*Lit); ok && c.Parent() == w {
+			c.Replace(&DblQuoted{Parts: []WordPart{&Lit{Value: "bar"}}})
+		}
+		return true
+	}, nil)
+	if want := "[foo bar]"; fmt.Sprint(seen) != want {
+		t.Fatalf("want %s, got %s", want, seen)
+	}
+	if want := "\"bar\""; printNode(w) != want {
+		t.Fatalf("want %q, got %q", want, printNode(w))
+	}
+
+	// replacing the root
+	root := Apply(parse("foo").Stmts[0], func(c *Cursor) bool {
+		if c.Parent() == nil {
+			c.Replace(parse("bar").Stmts[0])
+			return false
+		}
+		return true
+	}, nil)
+	if want := "bar"; printNode(root) != want {
+		t.Fatalf("want %q, got %q", want, printNode(root))
+	}
+
+	// test commands are walked via their arguments, which are shared with
+	// their expressions
+	f, err := NewParser(TestCmds(true)).Parse(strings.NewReader("[ -f foo -a x = y ]"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen = nil
+	Walk(f, func(node Node) bool {
+		if w, ok := node.(*Word); ok {
+			seen = append(seen, w.Lit())
+		}
+		return true
+	})
+	if want := "[[ -f foo -a x = y ]]"; fmt.Sprint(seen) != want {
+		t.Fatalf("want %s, got %s", want, seen)
+	}
+	Apply(f, func(c *Cursor) bool {
+		if w, ok := c.Node().(*Word); ok && w.Lit() == "x" {
+			lit := &Lit{ValuePos: w.Pos(), ValueEnd: w.End(), Value: "RENAMED"}
+			c.Replace(&Word{Parts: []WordPart{lit}})
+		}
+		return true
+	}, nil)
+	if want := "[ -f foo -a RENAMED = y ]\n"; printNode(f) != want {
+		t.Fatalf("want %q, got %q", want, printNode(f))
+	}
+	tc := f.Stmts[0].Cmd.(*TestCmd)
+	if want, got := "(&& (-f foo) (= RENAMED y))", testExprString(t, tc.X); got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+
+	// alias clauses are printed from their definitions
+	f, err = NewParser(AliasClauses(true)).Parse(strings.NewReader("alias ll='ls -l' la"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen = nil
+	Apply(f, func(c *Cursor) bool {
+		if lit, ok := c.Node().(*Lit); ok {
+			seen = append(seen, lit.Value)
+			if lit.Value == "ll" {
+				c.Replace(&Lit{ValuePos: lit.ValuePos, ValueEnd: lit.ValueEnd, Value: "l"})
+			}
+		}
+		return true
+	}, nil)
+	if want := "[alias ll la]"; fmt.Sprint(seen) != want {
+		t.Fatalf("want %s, got %s", want, seen)
+	}
+	if want := "alias l='ls -l' la\n"; printNode(f) != want {
+		t.Fatalf("want %q, got %q", want, printNode(f))
+	}
+}
+
+func TestApplyInvalidReplace(t *testing.T) {
+	t.Parallel()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("did not panic")
+		}
+	}()
+	f, err := NewParser().Parse(strings.NewReader("foo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Apply(f, func(c *Cursor) bool {
+		if _, ok := c.Node().(*CallExpr); ok {
+			c.Replace(&Lit{Value: "bar"})
+		}
+		return true
+	}, nil)
+}
+
+func TestFunctions(t *testing.T) {
+	t.Parallel()
+	src := `
+foo() { bar() { :; }; }
+function baz { :; }
+( qux() { :; } )
+if true; then function quux() { :; }; fi
+foo() (:)
+echo foo
+`
+	f, err := NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := Functions(f)
+	var got []string
+	for name, fd := range funcs {
+		if name != fd.Name.Value {
+			t.Errorf("%q key mismatches its name %q", name, fd.Name.Value)
+		}
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{"bar", "baz", "foo", "quux", "qux"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want functions %q, got %q", want, got)
+	}
+	if !funcs["baz"].RsrvWord || funcs["qux"].RsrvWord {
+		t.Errorf("wrong RsrvWord values for baz and qux")
+	}
+	// the last declaration wins
+	if got, want := string(NodeBytes([]byte(src), funcs["foo"])), "foo() (:)"; got != want {
+		t.Errorf("want foo to be %q, got %q", want, got)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	t.Parallel()
+	src := `
+global=1
+arr=(a b) arr[2]=c
+arr+=(d)
+FOO=bar BAR+=baz cmd
+export PATH
+f() {
+	local -a l1=(x) l2
+	declare d1=1
+	declare -g g1=2
+	readonly r1=3
+	l1+=(y)
+}
+declare d2=4
+`
+	f, err := NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type assign struct {
+		name          string
+		append, local bool
+		decl          string
+	}
+	want := []assign{
+		{"global", false, false, ""},
+		{"arr", false, false, ""},
+		{"arr", false, false, ""},
+		{"arr", true, false, ""},
+		{"FOO", false, false, ""},
+		{"BAR", true, false, ""},
+		{"PATH", false, false, "export"},
+		{"l1", false, true, "local"},
+		{"l2", false, true, "local"},
+		{"d1", false, true, "declare"},
+		{"g1", false, false, "declare"},
+		{"r1", false, false, "readonly"},
+		{"l1", true, false, ""},
+		{"d2", false, false, "declare"},
+	}
+	var got []assign
+	for _, as := range Assignments(f) {
+		a := assign{as.Name.Value, as.Append, as.Local, ""}
+		if as.Decl != nil {
+			a.decl = as.Decl.Variant.Value
+		}
+		got = append(got, a)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("assignments mismatch\nwant: %v\ngot:  %v", want, got)
+	}
+}
+
+func TestEnclosing(t *testing.T) {
+	t.Parallel()
+	src := "foo\nif a; then\n\tif b; then\n\t\techo bar\n\tfi\nfi\ncat <<EOF\nbody\nEOF\n"
+	f, err := NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at   string // the position is the first byte of this substring
+		want string
+	}{
+		{"foo", "*syntax.File *syntax.Stmt *syntax.CallExpr *syntax.Word *syntax.Lit"},
+		{"ar\n", "*syntax.File *syntax.Stmt *syntax.IfClause *syntax.Stmt " +
+			"*syntax.IfClause *syntax.Stmt *syntax.CallExpr *syntax.Word *syntax.Lit"},
+		{"\t\techo", "*syntax.File *syntax.Stmt *syntax.IfClause *syntax.Stmt *syntax.IfClause"},
+		{" b;", "*syntax.File *syntax.Stmt *syntax.IfClause *syntax.Stmt *syntax.IfClause"},
+		{"\nif", "*syntax.File"},
+		{"ody", "*syntax.File *syntax.Stmt *syntax.Redirect *syntax.Word *syntax.Lit"},
+	}
+	for _, tc := range tests {
+		offs := strings.Index(src, tc.at)
+		pos := Pos{line: 1, col: 1}.Advance(offs)
+		var got []string
+		for _, node := range Enclosing(f, pos) {
+			got = append(got, fmt.Sprintf("%T", node))
+		}
+		if got := strings.Join(got, " "); got != tc.want {
+			t.Errorf("Enclosing at %q:\nwant: %s\ngot:  %s", tc.at, tc.want, got)
+		}
+	}
+	past := Pos{line: 1, col: 1}.Advance(len(src) + 10)
+	if got := Enclosing(f, past); len(got) != 1 || got[0] != f {
+		t.Errorf("Enclosing past the end should only return the root")
+	}
+}
+
+func TestDebugPrint(t *testing.T) {
+	t.Parallel()
+	f, err := NewParser().Parse(strings.NewReader("foo 2>&1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := DebugPrint(&sb, f.Stmts[0].Redirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	want := `*syntax.Redirect {
+.  OpPos: 1:6
+.  Op: 0x3b (>&)
+.  N: *syntax.Lit {
+.  .  ValuePos: 1:5
+.  .  ValueEnd: 1:6
+.  .  Value: "2"
+.  }
+.  Word: *syntax.Word {
+.  .  Parts: []syntax.WordPart (len = 1) {
+.  .  .  0: *syntax.Lit {
+.  .  .  .  ValuePos: 1:8
+.  .  .  .  ValueEnd: 1:9
+.  .  .  .  Value: "1"
+.  .  .  }
+.  .  }
+.  }
+.  Hdoc: nil
+}`
+	if got := sb.String(); got != want {
+		t.Fatalf("DebugPrint mismatch:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"foo bar", "foo bar", true},
+		{"foo  bar;baz", "foo bar\nbaz", true},
+		{"if a; then b; fi", "if a\nthen\n\tb\nfi", true},
+		{"$((1+2)) ${a[1]}", "$(( 1 + 2 )) ${a[ 1 ]}", true},
+		{"a=(b c)", "a=( b  c )", true},
+		{"foo bar", "foo baz", false},
+		{"foo bar", "foo 'bar'", false},
+		{"foo; bar", "foo && bar", false},
+		{"foo >a", "foo >>a", false},
+		{"a=(b c)", "a=(b)", false},
+		{"foo", "foo &", false},
+		{"foo # bar", "foo", false},
+	}
+	p := NewParser(KeepComments(true))
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			a, err := p.Parse(strings.NewReader(tc.a), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := p.Parse(strings.NewReader(tc.b), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Equal(a, b); got != tc.want {
+				t.Fatalf("Equal(%q, %q): want %v, got %v",
+					tc.a, tc.b, tc.want, got)
+			}
+		})
+	}
+	// Nil and empty slices are equal.
+	if !Equal(&CallExpr{Args: litWords("foo")}, &CallExpr{
+		Assigns: []*Assign{},
+		Args:    litWords("foo"),
+	}) {
+		t.Fatalf("Equal should treat nil and empty slices as equal")
+	}
+	if Equal(&Lit{Value: "foo"}, &Word{Parts: []WordPart{lit("foo")}}) {
+		t.Fatalf("Equal should not treat different node types as equal")
+	}
+}
+
+func TestEqualFileTests(t *testing.T) {
+	t.Parallel()
+	// All the inputs of each test case result in the same syntax tree,
+	// other than positions. The exceptions are backquotes and for loops
+	// with braces, as the tree records their use.
+	parser := NewParser()
+	for i, c := range fileTests {
+		if c.Bash == nil {
+			continue
+		}
+		var first *File
+		var firstIn string
+		for j, in := range c.Strs {
+			if strings.Contains(in, "`") || strings.Contains(in, "; {") {
+				continue
+			}
+			f, err := parser.Parse(strings.NewReader(in), "")
+			if err != nil {
+				t.Fatalf("%03d-%d: %v", i, j, err)
+			}
+			if first == nil {
+				first, firstIn = f, in
+			} else if !Equal(first, f) {
+				t.Errorf("%03d-%d: %q should be equal to %q",
+					i, j, in, firstIn)
+			}
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	t.Parallel()
+	if Copy(nil) != nil {
+		t.Fatalf("Copy(nil) should be nil")
+	}
+	f, err := NewParser().Parse(strings.NewReader("foo bar; baz"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2 := Copy(f).(*File)
+	if !Equal(f, f2) {
+		t.Fatalf("a copy should be equal to the original")
+	}
+	call := f2.Stmts[0].Cmd.(*CallExpr)
+	call.Args[1].Parts[0].(*Lit).Value = "changed"
+	call.Args = append(call.Args[:1], litWord("added"))
+	f2.Stmts = f2.Stmts[:1]
+	got, err := strPrint(NewPrinter(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "foo bar\nbaz\n"; got != want {
+		t.Fatalf("modifying a copy changed the original:\nwant: %q\ngot:  %q",
+			want, got)
+	}
+}
+
+func TestApplyWalkOrder(t *testing.T) {
+	t.Parallel()
+	f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(
+		"# header\n\nfoo # a\ncase x in\ny) bar ;; # b\nesac # c\narr=(1 # d\n)"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walked, applied []string
+	Walk(f, func(node Node) bool {
+		if node != nil {
+			walked = append(walked, fmt.Sprintf("%T", node))
+		}
+		return true
+	})
+	Apply(f, func(c *Cursor) bool {
+		applied = append(applied, fmt.Sprintf("%T", c.Node()))
+		return true
+	}, func(c *Cursor) bool {
+		if s, ok := c.Node().(*Stmt); ok && len(s.Comments) > 0 {
+			// trailing comments are visited before post
+			if last := applied[len(applied)-1]; last != "*syntax.Comment" {
+				t.Errorf("want a comment visited before post, got %s", last)
+			}
+		}
+		return true
+	})
+	if fmt.Sprint(walked) != fmt.Sprint(applied) {
+		t.Fatalf("Walk and Apply orders differ:\n%v\n%v", walked, applied)
+	}
+}
+
+func TestCopyTestCmd(t *testing.T) {
+	t.Parallel()
+	f, err := NewParser(TestCmds(true)).Parse(strings.NewReader("[ a = b ]"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2 := Copy(f).(*File)
+	tc := f2.Stmts[0].Cmd.(*TestCmd)
+	if tc.X.(*BinaryTest).X != tc.Args[1] {
+		t.Fatalf("the copy does not share words between X and Args")
+	}
+	Apply(f2, func(c *Cursor) bool {
+		if w, ok := c.Node().(*Word); ok && w.Lit() == "a" {
+			c.Replace(&Word{Parts: []WordPart{
+				&Lit{ValuePos: w.Pos(), ValueEnd: w.End(), Value: "c"},
+			}})
+		}
+		return true
+	}, nil)
+	if got := tc.X.(*BinaryTest).X.(*Word).Lit(); got != "c" {
+		t.Fatalf("want X to be updated after Apply, got %q", got)
+	}
+	for _, tc := range []struct {
+		f    *File
+		want string
+	}{
+		{f2, "[ c = b ]\n"},
+		{f, "[ a = b ]\n"}, // the original is unchanged
+	} {
+		got, err := strPrint(NewPrinter(), tc.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("want %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestCopyFileTests(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	for i, c := range fileTests {
+		if c.Bash == nil {
+			continue
+		}
+		f, err := parser.Parse(strings.NewReader(c.Strs[0]), "")
+		if err != nil {
+			t.Fatalf("%03d: %v", i, err)
+		}
+		f2 := Copy(f).(*File)
+		if !reflect.DeepEqual(f, f2) {
+			t.Fatalf("%03d: copy of %q is not equal", i, c.Strs[0])
+		}
+		// No node may be shared between the two trees.
+		seen := make(map[Node]bool)
+		Walk(f, func(node Node) bool {
+			seen[node] = true
+			return true
+		})
+		Walk(f2, func(node Node) bool {
+			if node != nil && seen[node] {
+				t.Fatalf("%03d: %T in %q is shared with the copy",
+					i, node, c.Strs[0])
+			}
+			return true
+		})
+	}
+}